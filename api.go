@@ -0,0 +1,439 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/pkg/errors"
+)
+
+// apiPrefix is where version 1 of the JSON API is served. It takes the same
+// session cookie as the web UI, and anything other than a GET needs the
+// X-CSRF-Token header from /api/v1/session.
+const apiPrefix = "/api/v1/"
+
+// apiError is the body of every failed API request.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// pendingRequest is the body of a new pending reservation. The fields are the
+// same as the reservation form's and are validated the same way.
+type pendingRequest struct {
+	Date       string     `json:"date"`
+	Club       int        `json:"club,omitempty"`
+	Courses    []int      `json:"courses,omitempty"`
+	Holes      int        `json:"holes,omitempty"`
+	GuestType  int        `json:"guest_type,omitempty"`
+	Guests     []apiGuest `json:"guests,omitempty"`
+	Players    int        `json:"players"`
+	Earliest   string     `json:"earliest,omitempty"`
+	Latest     string     `json:"latest,omitempty"`
+	Preference string     `json:"preference,omitempty"`
+	Watch      bool       `json:"watch,omitempty"`
+}
+
+// apiGuest is a buddy booked into a pending reservation. AffiliationType
+// prices them instead of the buddy's own type or the reservation's
+// guest_type.
+type apiGuest struct {
+	ID              string `json:"id"`
+	AffiliationType int    `json:"affiliation_type,omitempty"`
+}
+
+// pendingUpdate is the body of a PATCH to a pending reservation. Only the
+// party size and time window can change; the rest of a reservation is fixed
+// once it's created, so those fields are rejected as unknown.
+type pendingUpdate struct {
+	Players    int    `json:"players"`
+	Earliest   string `json:"earliest,omitempty"`
+	Latest     string `json:"latest,omitempty"`
+	Preference string `json:"preference,omitempty"`
+	Watch      bool   `json:"watch,omitempty"`
+}
+
+// form returns the update form the request is equivalent to.
+func (p pendingUpdate) form() url.Values {
+	return pendingRequest{
+		Players:    p.Players,
+		Earliest:   p.Earliest,
+		Latest:     p.Latest,
+		Preference: p.Preference,
+		Watch:      p.Watch,
+	}.form()
+}
+
+// form returns the reservation form the request is equivalent to.
+func (p pendingRequest) form() url.Values {
+	form := url.Values{}
+	set := func(key string, v int) {
+		if v != 0 {
+			form.Set(key, strconv.Itoa(v))
+		}
+	}
+	form.Set("date", p.Date)
+	set("club", p.Club)
+	for _, id := range p.Courses {
+		form.Add("course", strconv.Itoa(id))
+	}
+	set("holes", p.Holes)
+	set("guest_type", p.GuestType)
+	for _, g := range p.Guests {
+		form.Add("guest", g.ID)
+		set("guest_type."+g.ID, g.AffiliationType)
+	}
+	form.Set("players", strconv.Itoa(p.Players))
+	form.Set("earliest", p.Earliest)
+	form.Set("latest", p.Latest)
+	form.Set("preference", p.Preference)
+	if p.Watch {
+		form.Set("watch", "on")
+	}
+	return form
+}
+
+// apiTeeTime is a tee time and the course it's on.
+type apiTeeTime struct {
+	Course  golfer.Course  `json:"course"`
+	TeeTime golfer.TeeTime `json:"tee_time"`
+}
+
+// apiClub is a club with everything that can be booked at it.
+type apiClub struct {
+	Club       golfer.Club              `json:"club"`
+	Courses    []golfer.Course          `json:"courses"`
+	GuestTypes []golfer.AffiliationType `json:"guest_types"`
+	// MemberTypes can only be booked for guests who are members.
+	MemberTypes []golfer.AffiliationType `json:"member_types"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// decodeJSON decodes the body of r into v, rejecting unknown fields so typos
+// aren't silently ignored.
+func decodeJSON(r *http.Request, v interface{}) error {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return errors.Wrap(err, "invalid JSON body")
+	}
+	return nil
+}
+
+// apiAuthed is authed for the API, failing with JSON errors instead of
+// redirecting to the login page.
+func (s *server) apiAuthed(h func(w http.ResponseWriter, r *http.Request, u *User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, valid := s.authenticate(r)
+		if u == nil {
+			writeJSONError(w, 401, errors.New("not logged in"))
+			return
+		}
+		if !valid {
+			writeJSONError(w, 403, errors.New("invalid CSRF token"))
+			return
+		}
+		h(w, r, u)
+	}
+}
+
+func (s *server) apiRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(apiPrefix, func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, 404, errors.Errorf("unknown API endpoint %s", r.URL.Path))
+	})
+	mux.HandleFunc(apiPrefix+"session", s.apiAuthed(s.handleAPISession))
+	mux.HandleFunc(apiPrefix+"pending", s.apiAuthed(s.handleAPIPending))
+	mux.HandleFunc(apiPrefix+"pending/", s.apiAuthed(s.handleAPIPendingItem))
+	mux.HandleFunc(apiPrefix+"book", s.apiAuthed(s.handleAPIBook))
+	mux.HandleFunc(apiPrefix+"reservations", s.apiAuthed(s.handleAPIReservations))
+	mux.HandleFunc(apiPrefix+"reservations/", s.apiAuthed(s.handleAPIReservation))
+	mux.HandleFunc(apiPrefix+"teetimes", s.apiAuthed(s.handleAPITeeTimes))
+	mux.HandleFunc(apiPrefix+"courses", s.apiAuthed(s.handleAPICourses))
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeJSONError(w, http.StatusMethodNotAllowed, errors.Errorf("must use %s", strings.Join(allowed, " or ")))
+}
+
+// handleAPISession returns the logged in user and the CSRF token to send
+// with changes.
+func (s *server) handleAPISession(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, 200, struct {
+		User string `json:"user"`
+		CSRF string `json:"csrf"`
+	}{u.Name, s.csrfToken(r)})
+}
+
+// handleAPIPending lists pending reservations on GET and adds one on POST.
+func (s *server) handleAPIPending(w http.ResponseWriter, r *http.Request, u *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		pending := u.Pending
+		if pending == nil {
+			pending = []*PendingReservation{}
+		}
+		writeJSON(w, 200, pending)
+
+	case http.MethodPost:
+		var req pendingRequest
+		if err := decodeJSON(r, &req); err != nil {
+			writeJSONError(w, 400, err)
+			return
+		}
+		pr, err := s.parseReserveForm(req.form(), u)
+		if err != nil {
+			writeJSONError(w, 400, err)
+			return
+		}
+		if status, err := s.addPending(u, pr); err != nil {
+			writeJSONError(w, status, err)
+			return
+		}
+		w.Header().Set("Location", apiPrefix+"pending/"+pr.ID)
+		writeJSON(w, http.StatusCreated, pr)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleAPIBook books a new reservation on a day that's already open before
+// responding. It takes the same body as a new pending reservation. Bookings
+// are 201 Created, 202 Accepted means no tee time was free and the
+// reservation was kept pending, and 502 means Chronogolf refused it. All
+// three have a bookNowResult body.
+func (s *server) handleAPIBook(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var req pendingRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSONError(w, 400, err)
+		return
+	}
+	pr, err := s.parseReserveForm(req.form(), u)
+	if err != nil {
+		writeJSONError(w, 400, err)
+		return
+	}
+	result, status, err := s.bookNow(u, pr)
+	if err != nil {
+		writeJSONError(w, status, err)
+		return
+	}
+	switch {
+	case result.Reservation != nil:
+		writeJSON(w, http.StatusCreated, result)
+	case result.Pending != nil:
+		w.Header().Set("Location", apiPrefix+"pending/"+pr.ID)
+		writeJSON(w, http.StatusAccepted, result)
+	default:
+		writeJSON(w, http.StatusBadGateway, result)
+	}
+}
+
+// handleAPIPendingItem handles a single pending reservation at
+// /api/v1/pending/{id}, and the actions on it at
+// /api/v1/pending/{id}/{pause,resume}. PATCH updates the party and time
+// window, see pendingUpdate.
+func (s *server) handleAPIPendingItem(w http.ResponseWriter, r *http.Request, u *User) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, apiPrefix+"pending/"), "/")
+	if len(parts) > 2 {
+		writeJSONError(w, 404, errors.Errorf("unknown API endpoint %s", r.URL.Path))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := u.findPending(parts[0])
+	if p == nil {
+		writeJSONError(w, 404, errors.New("unknown pending reservation"))
+		return
+	}
+
+	var action string
+	form := url.Values{}
+	if len(parts) == 2 {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		action = parts[1]
+	} else {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, 200, p)
+			return
+		case http.MethodDelete:
+			action = "delete"
+		case http.MethodPatch:
+			req := pendingUpdate{
+				Players:    p.Players,
+				Earliest:   p.Earliest,
+				Latest:     p.Latest,
+				Preference: string(p.Preference),
+				Watch:      p.Watch,
+			}
+			if err := decodeJSON(r, &req); err != nil {
+				writeJSONError(w, 400, err)
+				return
+			}
+			action = "update"
+			form = req.form()
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete, http.MethodPatch)
+			return
+		}
+	}
+	if status, err := s.pendingAction(u, p, action, form); err != nil {
+		writeJSONError(w, status, err)
+		return
+	}
+	if action == "delete" {
+		writeJSON(w, http.StatusNoContent, nil)
+		return
+	}
+	writeJSON(w, 200, p)
+}
+
+// handleAPIReservations lists the upcoming Chronogolf reservations.
+func (s *server) handleAPIReservations(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, err := u.golfer()
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+	reservations, err := g.Reservations()
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+	if reservations == nil {
+		reservations = []golfer.Reservation{}
+	}
+	writeJSON(w, 200, reservations)
+}
+
+// handleAPIReservation cancels the Chronogolf reservation at
+// /api/v1/reservations/{id} on DELETE.
+func (s *server) handleAPIReservation(w http.ResponseWriter, r *http.Request, u *User) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, apiPrefix+"reservations/"))
+	if err != nil {
+		writeJSONError(w, 404, errors.Wrap(err, "invalid reservation id"))
+		return
+	}
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, err := u.golfer()
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+	res, err := g.CancelReservation(id)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, errors.Wrap(err, "failed to cancel reservation"))
+		return
+	}
+	writeJSON(w, 200, res)
+}
+
+// handleAPITeeTimes lists the tee times on a day, best first. It takes the
+// fields of a new reservation as query parameters, with date being a day and
+// the window defaulting to the whole day.
+func (s *server) handleAPITeeTimes(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	s.mu.Lock()
+	p, err := s.parseTeeTimesForm(r.URL.Query(), u)
+	if err != nil {
+		s.mu.Unlock()
+		writeJSONError(w, 400, err)
+		return
+	}
+	g, err := u.golfer()
+	s.mu.Unlock()
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+	candidates, _, err := s.teeTimes(g, *p)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+	tts := []apiTeeTime{}
+	for _, c := range candidates {
+		tts = append(tts, apiTeeTime{Course: c.course, TeeTime: c.tt})
+	}
+	writeJSON(w, 200, tts)
+}
+
+// handleAPICourses lists the clubs flog books at with their courses and the
+// rates guests can be booked with.
+func (s *server) handleAPICourses(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clubs := []apiClub{}
+	for _, c := range s.clubs {
+		clubs = append(clubs, apiClub{
+			Club:        c,
+			Courses:     s.courses[c.ID],
+			GuestTypes:  s.guestTypes[c.ID],
+			MemberTypes: s.memberTypes[c.ID],
+		})
+	}
+	writeJSON(w, 200, clubs)
+}
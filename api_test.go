@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+// apiDo sends body as JSON to h and decodes the response into out.
+func apiDo(t *testing.T, h http.Handler, method, path, body string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); w.Code != http.StatusNoContent && !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("%s %s Content-Type = %q", method, path, ct)
+	}
+	if out != nil && w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %s: %v", method, path, w.Body, err)
+		}
+	}
+	return w
+}
+
+func TestAPIPending(t *testing.T) {
+	s, _ := newTestServer(t)
+	h := testHandler(s)
+
+	var pending []PendingReservation
+	if w := apiDo(t, h, "GET", "/api/v1/pending", "", &pending); w.Code != 200 || len(pending) != 0 {
+		t.Fatalf("GET /api/v1/pending = %d: %s", w.Code, w.Body)
+	}
+
+	for _, body := range []string{
+		`{"date":"2018-05-25T07:10","players":9}`,
+		`{"date":"tomorrow","players":2}`,
+		`{"date":"2018-05-25T07:10","players":2,"courses":[999]}`,
+		`{"date":"2018-05-25T07:10","players":2,"earliest":"09:00","latest":"08:00"}`,
+		`{"date":"2018-05-25T07:10","players":2,"guests":[{"id":"nobody"}]}`,
+		`{"date":"2018-05-25T07:10","playres":2}`,
+		`not json`,
+	} {
+		var e apiError
+		w := apiDo(t, h, "POST", "/api/v1/pending", body, nil)
+		if err := json.Unmarshal(w.Body.Bytes(), &e); w.Code != 400 || err != nil || e.Error == "" {
+			t.Errorf("POST %s = %d: %s", body, w.Code, w.Body)
+		}
+	}
+
+	body := `{"date":"2018-05-25T07:10","players":2,"earliest":"07:00","latest":"08:00","courses":[` + strconv.Itoa(golfertest.CourseID) + `]}`
+	var created PendingReservation
+	w := apiDo(t, h, "POST", "/api/v1/pending", body, &created)
+	if w.Code != http.StatusCreated || created.ID == "" || created.Players != 2 || created.State != StateWaiting {
+		t.Fatalf("POST /api/v1/pending = %d: %s", w.Code, w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "/api/v1/pending/"+created.ID {
+		t.Errorf("Location = %q", loc)
+	}
+	if w := apiDo(t, h, "POST", "/api/v1/pending", body, nil); w.Code != http.StatusConflict {
+		t.Errorf("POST a duplicate = %d: %s", w.Code, w.Body)
+	}
+
+	item := "/api/v1/pending/" + created.ID
+	var got PendingReservation
+	if w := apiDo(t, h, "GET", item, "", &got); w.Code != 200 || got.ID != created.ID {
+		t.Errorf("GET %s = %d: %s", item, w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "PATCH", item, `{"players":3,"latest":"09:00"}`, &got); w.Code != 200 || got.Players != 3 || got.Latest != "09:00" || got.Earliest != "07:00" {
+		t.Errorf("PATCH %s = %d: %s", item, w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "PATCH", item, `{"players":0}`, nil); w.Code != 400 {
+		t.Errorf("PATCH %s with no players = %d: %s", item, w.Code, w.Body)
+	}
+	for _, body := range []string{`{"players":2,"date":"2018-05-18"}`, `{"club":1}`, `{"courses":[1]}`, `{"holes":9}`, `{"guest_type":1}`, `{"guests":[]}`} {
+		if w := apiDo(t, h, "PATCH", item, body, nil); w.Code != 400 || !strings.Contains(w.Body.String(), "unknown field") {
+			t.Errorf("PATCH %s with %s = %d: %s", item, body, w.Code, w.Body)
+		}
+	}
+	if w := apiDo(t, h, "GET", item, "", &got); w.Code != 200 || got.Players != 3 || got.Day != created.Day {
+		t.Errorf("GET %s after rejected PATCHes = %d: %s", item, w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "POST", item+"/pause", "", &got); w.Code != 200 || got.State != StatePaused {
+		t.Errorf("pause = %d: %s", w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "POST", item+"/pause", "", nil); w.Code != 400 {
+		t.Errorf("pausing twice = %d: %s", w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "POST", item+"/resume", "", &got); w.Code != 200 || got.State != StateWaiting {
+		t.Errorf("resume = %d: %s", w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "POST", item+"/explode", "", nil); w.Code != 404 {
+		t.Errorf("unknown action = %d: %s", w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "PUT", item, "", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT %s = %d: %s", item, w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "DELETE", item, "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE %s = %d: %s", item, w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "GET", item, "", nil); w.Code != 404 {
+		t.Errorf("GET deleted %s = %d: %s", item, w.Code, w.Body)
+	}
+}
+
+func TestAPIGuestTypes(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Users[0].Buddies = []*Buddy{
+		{ID: "mia", Player: golfer.Player{FirstName: "Mia", LastName: "Member", MemberNo: "42"}},
+		{ID: "gus", Player: golfer.Player{FirstName: "Gus", LastName: "Guest"}},
+	}
+	h := testHandler(s)
+
+	member, public := golfertest.MemberAffiliationTypeID, golfertest.PublicAffiliationTypeID
+	for _, guests := range []string{
+		`[{"id":"gus","affiliation_type":` + strconv.Itoa(member) + `}]`,
+		`[{"id":"gus","affiliation_type":999}]`,
+		`["gus"]`,
+	} {
+		body := `{"date":"2018-05-25T07:10","players":2,"guests":` + guests + `}`
+		if w := apiDo(t, h, "POST", "/api/v1/pending", body, nil); w.Code != 400 {
+			t.Errorf("POST %s = %d: %s", body, w.Code, w.Body)
+		}
+	}
+
+	body := `{"date":"2018-05-25T07:10","players":3,"guests":[{"id":"mia","affiliation_type":` + strconv.Itoa(member) + `},{"id":"gus","affiliation_type":` + strconv.Itoa(public) + `}]}`
+	var created PendingReservation
+	if w := apiDo(t, h, "POST", "/api/v1/pending", body, &created); w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/v1/pending = %d: %s", w.Code, w.Body)
+	}
+	if g := created.Guests; len(g) != 2 || g[0].AffiliationTypeID != member || g[1].AffiliationTypeID != public {
+		t.Errorf("Guests = %+v", g)
+	}
+}
+
+func TestAPIBook(t *testing.T) {
+	s, fake := newTestServer(t)
+	tt := fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:20", 4)
+	h := testHandler(s)
+
+	// 2018-05-25 isn't open for booking yet.
+	if w := apiDo(t, h, "POST", "/api/v1/book", `{"date":"2018-05-25T07:10","players":2}`, nil); w.Code != 400 || !strings.Contains(w.Body.String(), errNotBookable.Error()) {
+		t.Errorf("POST a closed day = %d: %s", w.Code, w.Body)
+	}
+
+	var booked bookNowResult
+	body := `{"date":"2018-05-17T07:10","players":2,"earliest":"07:00","latest":"08:00"}`
+	w := apiDo(t, h, "POST", "/api/v1/book", body, &booked)
+	if w.Code != http.StatusCreated || booked.Reservation == nil || booked.Reservation.TeetimeID != tt.ID || booked.Pending != nil || len(booked.Attempts) != 1 {
+		t.Fatalf("POST /api/v1/book = %d: %s", w.Code, w.Body)
+	}
+	if reservations := fake.Reservations(); len(reservations) != 1 {
+		t.Errorf("reservations = %+v", reservations)
+	}
+	s.mu.Lock()
+	if p := s.Users[0].Pending; len(p) != 1 || p[0].State != StateBooked || len(s.Users[0].History) != 1 {
+		t.Errorf("pending = %+v, history = %+v", p, s.Users[0].History)
+	}
+	s.mu.Unlock()
+
+	// The only tee time is now full so the next booking is kept pending.
+	var full bookNowResult
+	body = `{"date":"2018-05-17T07:10","players":3,"earliest":"07:00","latest":"08:00"}`
+	w = apiDo(t, h, "POST", "/api/v1/book", body, &full)
+	if w.Code != http.StatusAccepted || full.Reservation != nil || full.Pending == nil || full.Error != errNoTeeTimes.Error() {
+		t.Fatalf("POST /api/v1/book when full = %d: %s", w.Code, w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "/api/v1/pending/"+full.Pending.ID {
+		t.Errorf("Location = %q", loc)
+	}
+	if full.Pending.State != StateFailed || !full.Pending.Active() {
+		t.Errorf("kept pending = %+v", full.Pending)
+	}
+	if w := apiDo(t, h, "POST", "/api/v1/book", body, nil); w.Code != http.StatusConflict {
+		t.Errorf("POST a duplicate = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAPIReservations(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:00", 4)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:30", 2)
+	h := testHandler(s)
+
+	var tts []apiTeeTime
+	if w := apiDo(t, h, "GET", "/api/v1/teetimes?date=2018-05-17&players=2", "", &tts); w.Code != 200 || len(tts) != 2 || tts[0].TeeTime.StartTime != "07:00" || tts[0].Course.ID != golfertest.CourseID {
+		t.Errorf("GET /api/v1/teetimes = %d: %s", w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "GET", "/api/v1/teetimes?date=2018-05-17&earliest=07:15", "", &tts); w.Code != 200 || len(tts) != 1 || tts[0].TeeTime.StartTime != "07:30" {
+		t.Errorf("GET /api/v1/teetimes after 07:15 = %d: %s", w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "GET", "/api/v1/teetimes?date=2018-05-17&players=5", "", nil); w.Code != 400 {
+		t.Errorf("GET /api/v1/teetimes for 5 players = %d: %s", w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "GET", "/api/v1/teetimes?date=2018-05-17T07:00", "", nil); w.Code != 400 {
+		t.Errorf("GET /api/v1/teetimes with a time = %d: %s", w.Code, w.Body)
+	}
+
+	var clubs []apiClub
+	if w := apiDo(t, h, "GET", "/api/v1/courses", "", &clubs); w.Code != 200 || len(clubs) != 1 || clubs[0].Club.ID != golfertest.ClubID || len(clubs[0].Courses) == 0 || len(clubs[0].MemberTypes) != 1 || len(clubs[0].GuestTypes) != 1 {
+		t.Errorf("GET /api/v1/courses = %d: %s", w.Code, w.Body)
+	}
+
+	// The day is open so the new reservation is booked straight away.
+	if w := apiDo(t, h, "POST", "/api/v1/pending", `{"date":"2018-05-17T07:00","players":2}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/v1/pending = %d: %s", w.Code, w.Body)
+	}
+	s.background.Wait()
+	var reservations []golfer.Reservation
+	if w := apiDo(t, h, "GET", "/api/v1/reservations", "", &reservations); w.Code != 200 || len(reservations) != 1 {
+		t.Fatalf("GET /api/v1/reservations = %d: %s", w.Code, w.Body)
+	}
+	var canceled golfer.Reservation
+	path := "/api/v1/reservations/" + strconv.Itoa(reservations[0].ID)
+	if w := apiDo(t, h, "DELETE", path, "", &canceled); w.Code != 200 || canceled.State != "canceled" {
+		t.Errorf("DELETE %s = %d: %s", path, w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "DELETE", path, "", nil); w.Code != http.StatusBadGateway {
+		t.Errorf("DELETE %s again = %d: %s", path, w.Code, w.Body)
+	}
+	if w := apiDo(t, h, "GET", "/api/v1/reservations", "", &reservations); w.Code != 200 || len(reservations) != 0 {
+		t.Errorf("GET /api/v1/reservations after cancel = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAPIAuth(t *testing.T) {
+	s, _ := newTestServer(t)
+	h := s.routes()
+	if w := apiDo(t, h, "GET", "/api/v1/pending", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/v1/pending logged out = %d: %s", w.Code, w.Body)
+	}
+
+	authed := testHandler(s)
+	var session struct {
+		User string `json:"user"`
+		CSRF string `json:"csrf"`
+	}
+	if w := apiDo(t, authed, "GET", "/api/v1/session", "", &session); w.Code != 200 || session.User != golfertest.User || session.CSRF == "" {
+		t.Errorf("GET /api/v1/session = %d: %s", w.Code, w.Body)
+	}
+	if w := apiDo(t, authed, "GET", "/api/v1/nope", "", nil); w.Code != 404 {
+		t.Errorf("GET /api/v1/nope = %d: %s", w.Code, w.Body)
+	}
+
+	// Without the CSRF header changes are refused.
+	req := httptest.NewRequest("POST", "/api/v1/pending", strings.NewReader(`{}`))
+	s.mu.Lock()
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: s.newSession(golfertest.User)})
+	s.mu.Unlock()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("POST without a CSRF token = %d: %s", w.Code, w.Body)
+	}
+}
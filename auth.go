@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/d4l3k/flog/golfer"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// sessionCookie holds the signed session of a logged in flog user.
+	sessionCookie = "flog_session"
+	// sessionLifetime is how long a login lasts.
+	sessionLifetime = 30 * 24 * time.Hour
+	// csrfField is the form field, or csrfHeader the header, every post has
+	// to carry the session's CSRF token in.
+	csrfField  = "csrf"
+	csrfHeader = "X-CSRF-Token"
+	// minPasswordLength is the shortest flog password allowed.
+	minPasswordLength = 8
+)
+
+// key returns the key sessions are signed with, creating it the first time.
+func (s *server) key() []byte {
+	if len(s.SessionKey) == 0 {
+		s.SessionKey = make([]byte, 32)
+		if _, err := rand.Read(s.SessionKey); err != nil {
+			panic(err)
+		}
+	}
+	return s.SessionKey
+}
+
+func (s *server) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, s.key())
+	mac.Write([]byte(strings.Join(parts, "|")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newSession returns a signed session cookie value logging name in until
+// sessionLifetime from now.
+func (s *server) newSession(name string) string {
+	user := base64.RawURLEncoding.EncodeToString([]byte(name))
+	expires := strconv.FormatInt(now().Add(sessionLifetime).Unix(), 10)
+	return strings.Join([]string{user, expires, s.sign(user, expires)}, "|")
+}
+
+// sessionUser returns the user logged in by r's session cookie, checking its
+// signature and expiry.
+func (s *server) sessionUser(r *http.Request) *User {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	parts := strings.Split(c.Value, "|")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(s.sign(parts[0], parts[1]))) {
+		return nil
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now().After(time.Unix(expires, 0)) {
+		return nil
+	}
+	name, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil
+	}
+	u := s.user(string(name))
+	if u == nil || len(u.LoginHash) == 0 {
+		return nil
+	}
+	return u
+}
+
+// csrfToken returns the CSRF token for r's session.
+func (s *server) csrfToken(r *http.Request) string {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	return s.sign("csrf", c.Value)
+}
+
+// validCSRF checks that a post carries the CSRF token of its session.
+func (s *server) validCSRF(r *http.Request) bool {
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		token = r.PostFormValue(csrfField)
+	}
+	want := s.csrfToken(r)
+	return want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// authenticate returns the user logged in by r and whether r can change
+// anything. Anything other than a GET needs the session's CSRF token.
+func (s *server) authenticate(r *http.Request) (*User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.sessionUser(r)
+	valid := r.Method == http.MethodGet || r.Method == http.MethodHead || s.validCSRF(r)
+	return u, valid
+}
+
+// authed wraps handlers that need a logged in user, sending everyone else
+// to the login page.
+func (s *server) authed(h func(w http.ResponseWriter, r *http.Request, u *User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, valid := s.authenticate(r)
+		if u == nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if !valid {
+			http.Error(w, "invalid CSRF token", 403)
+			return
+		}
+		h(w, r, u)
+	}
+}
+
+func (s *server) setSession(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.newSession(name),
+		Path:     "/",
+		MaxAge:   int(sessionLifetime / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// handleLogin logs users in with their flog password.
+func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderMarkdown(w, "login.md", nil)
+
+	case http.MethodPost:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		u := s.user(strings.TrimSpace(r.FormValue("email")))
+		if u == nil || len(u.LoginHash) == 0 || bcrypt.CompareHashAndPassword(u.LoginHash, []byte(r.FormValue("password"))) != nil {
+			http.Error(w, "invalid email or password", 401)
+			return
+		}
+		s.setSession(w, u.Name)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	default:
+		http.Error(w, "must use get or post", 400)
+	}
+}
+
+// handleSignup sets the flog password of a user after checking their
+// Chronogolf account. Users flog hasn't seen before are added.
+func (s *server) handleSignup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "must use post", 400)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("email"))
+	chronogolf := r.FormValue("chronogolf")
+	password := r.FormValue("password")
+	if name == "" || chronogolf == "" {
+		http.Error(w, "need a Chronogolf email and password", 400)
+		return
+	}
+	if len(password) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("flog passwords need to be at least %d characters", minPasswordLength), 400)
+		return
+	}
+
+	// Logging into Chronogolf and hashing the password are slow, so they're
+	// done before taking s.mu.
+	g, err := golfer.New(name, golfer.Password(chronogolf), golferOpts...)
+	if err != nil {
+		log.Printf("%+v", err)
+		http.Error(w, "invalid Chronogolf email or password", 401)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%+v", err), 500)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u := s.user(name); u != nil && len(u.LoginHash) > 0 {
+		http.Error(w, "already signed up, log in instead", 400)
+		return
+	}
+	// Keep the password out of the data file when there's a secrets file.
+	var creds golfer.Credentials = golfer.Password(chronogolf)
+	if *secretsFile != "" {
+		if err := storeSecret(name, chronogolf); err != nil {
+			http.Error(w, fmt.Sprintf("failed to save password: %+v", err), 500)
+			return
+		}
+		creds = secretsCredentials(name)
+	}
+	u := s.addUser(name, creds)
+	u.g = g
+	u.LoginHash = hash
+	if err := s.savePending(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to save users: %+v", err), 500)
+		return
+	}
+	s.setSession(w, u.Name)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *server) handleLogout(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "must use post", 400)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+// client is a browser for tests: it keeps the session cookie and posts the
+// CSRF token from the last page it loaded.
+type client struct {
+	t       *testing.T
+	h       http.Handler
+	session *http.Cookie
+	csrf    string
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf" value="([^"]+)"`)
+
+func (c *client) do(method, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.session != nil {
+		req.AddCookie(c.session)
+	}
+	w := httptest.NewRecorder()
+	c.h.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			c.session = cookie
+		}
+	}
+	if m := csrfInput.FindStringSubmatch(w.Body.String()); m != nil {
+		c.csrf = m[1]
+	}
+	return w
+}
+
+// post loads the index page for a CSRF token and posts form with it.
+func (c *client) post(path string, form url.Values) *httptest.ResponseRecorder {
+	if w := c.do("GET", "/", nil); w.Code != http.StatusOK {
+		c.t.Fatalf("GET / = %d: %s", w.Code, w.Body)
+	}
+	withToken := url.Values{csrfField: {c.csrf}}
+	for k, v := range form {
+		withToken[k] = v
+	}
+	return c.do("POST", path, withToken)
+}
+
+func TestAuth(t *testing.T) {
+	s, _ := newTestServer(t)
+	h := s.routes()
+	c := &client{t: t, h: h}
+
+	if w := c.do("GET", "/", nil); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Errorf("GET / logged out = %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := c.do("GET", "/login", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /login = %d", w.Code)
+	}
+	// Accounts from -user need to sign up before they can log in.
+	if w := c.do("POST", "/login", url.Values{"email": {golfertest.User}, "password": {golfertest.Password}}); w.Code != http.StatusUnauthorized {
+		t.Errorf("login before signing up = %d", w.Code)
+	}
+
+	for _, form := range []url.Values{
+		{"email": {golfertest.User}, "chronogolf": {golfertest.Password}, "password": {"short"}},
+		{"email": {golfertest.User}, "chronogolf": {"wrong"}, "password": {"long enough"}},
+		{"email": {"stranger@example.com"}, "chronogolf": {"birdie"}, "password": {"long enough"}},
+	} {
+		if w := c.do("POST", "/signup", form); w.Code == http.StatusSeeOther {
+			t.Errorf("POST /signup %v succeeded", form)
+		}
+	}
+	if w := c.do("POST", "/signup", url.Values{"email": {golfertest.User}, "chronogolf": {golfertest.Password}, "password": {"long enough"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /signup = %d: %s", w.Code, w.Body)
+	}
+	if string(s.Users[0].LoginHash) == "long enough" || len(s.Users[0].LoginHash) == 0 {
+		t.Errorf("LoginHash = %q", s.Users[0].LoginHash)
+	}
+	if w := c.do("POST", "/signup", url.Values{"email": {golfertest.User}, "chronogolf": {golfertest.Password}, "password": {"taking over"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("signing up twice = %d", w.Code)
+	}
+
+	c = &client{t: t, h: h}
+	if w := c.do("POST", "/login", url.Values{"email": {golfertest.User}, "password": {"wrong"}}); w.Code != http.StatusUnauthorized {
+		t.Errorf("login with the wrong password = %d", w.Code)
+	}
+	if w := c.do("POST", "/login", url.Values{"email": {golfertest.User}, "password": {"long enough"}}); w.Code != http.StatusSeeOther || c.session == nil {
+		t.Fatalf("login = %d: %s", w.Code, w.Body)
+	}
+	if w := c.do("GET", "/", nil); w.Code != http.StatusOK || c.csrf == "" {
+		t.Fatalf("GET / = %d, csrf %q", w.Code, c.csrf)
+	}
+
+	// Posts need the session's CSRF token.
+	if w := c.do("POST", "/cancel", nil); w.Code != http.StatusForbidden {
+		t.Errorf("POST /cancel without a CSRF token = %d", w.Code)
+	}
+	if w := c.do("POST", "/cancel", url.Values{csrfField: {"forged"}}); w.Code != http.StatusForbidden {
+		t.Errorf("POST /cancel with a forged CSRF token = %d", w.Code)
+	}
+	if w := c.post("/cancel", nil); w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("POST /cancel = %d: %s", w.Code, w.Body)
+	}
+
+	// Sessions are signed and expire.
+	good := *c.session
+	forged := good
+	forged.Value = strings.Replace(forged.Value, "|", "x|", 1)
+	c.session = &forged
+	if w := c.do("GET", "/", nil); w.Code != http.StatusSeeOther {
+		t.Errorf("GET / with a forged session = %d", w.Code)
+	}
+	c.session = &good
+	newFakeClock(now().Add(sessionLifetime + time.Minute))
+	if w := c.do("GET", "/", nil); w.Code != http.StatusSeeOther {
+		t.Errorf("GET / with an expired session = %d", w.Code)
+	}
+}
+
+func TestSignupTwiceAtOnce(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddUser("friend@example.com", "birdie")
+	h := s.routes()
+
+	codes := make(chan int, 2)
+	for _, password := range []string{"first password", "second password"} {
+		go func(password string) {
+			c := &client{t: t, h: h}
+			w := c.do("POST", "/signup", url.Values{"email": {"friend@example.com"}, "chronogolf": {"birdie"}, "password": {password}})
+			codes <- w.Code
+		}(password)
+	}
+	got := map[int]int{}
+	for i := 0; i < 2; i++ {
+		got[<-codes]++
+	}
+	if got[http.StatusSeeOther] != 1 || got[http.StatusBadRequest] != 1 {
+		t.Errorf("signup status codes = %v", got)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.Users) != 2 {
+		t.Errorf("Users = %+v", s.Users)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltMetaBucket  = []byte("meta")
+	boltUsersBucket = []byte("users")
+)
+
+// boltStore saves data in a bbolt database. The meta bucket holds the
+// top-level fields of Data and the users bucket holds each user in order.
+// Values are JSON so the same migrations apply as for the data file.
+type boltStore struct {
+	db   *bolt.DB
+	path string
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, dataFileMode, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", path)
+	}
+	return &boltStore{db: db, path: path}, nil
+}
+
+func (b *boltStore) Load() (*Data, error) {
+	var data map[string]interface{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(boltMetaBucket)
+		if meta == nil {
+			return nil
+		}
+		data = map[string]interface{}{}
+		if err := meta.ForEach(func(k, v []byte) error {
+			var field interface{}
+			if err := json.Unmarshal(v, &field); err != nil {
+				return errors.Wrapf(err, "decoding %s", k)
+			}
+			data[string(k)] = field
+			return nil
+		}); err != nil {
+			return err
+		}
+		users := tx.Bucket(boltUsersBucket)
+		if users == nil {
+			return nil
+		}
+		var all []interface{}
+		if err := users.ForEach(func(k, v []byte) error {
+			var u interface{}
+			if err := json.Unmarshal(v, &u); err != nil {
+				return errors.Wrapf(err, "decoding user %d", binary.BigEndian.Uint64(k))
+			}
+			all = append(all, u)
+			return nil
+		}); err != nil {
+			return err
+		}
+		data["Users"] = all
+		return nil
+	})
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return upgradeData(data, b.path)
+}
+
+// Save replaces everything in the database in a single transaction.
+func (b *boltStore) Save(d *Data) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltMetaBucket, boltUsersBucket} {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucket(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		for k, v := range map[string]interface{}{
+			"DataFormatVersion": d.DataFormatVersion,
+			"SessionKey":        d.SessionKey,
+		} {
+			buf, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if err := meta.Put([]byte(k), buf); err != nil {
+				return err
+			}
+		}
+		users, err := tx.CreateBucket(boltUsersBucket)
+		if err != nil {
+			return err
+		}
+		for i, u := range d.Users {
+			buf, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(i))
+			if err := users.Put(key, buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
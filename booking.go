@@ -0,0 +1,339 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/pkg/errors"
+)
+
+var (
+	bookDeadline = flag.Duration("book-deadline", 30*time.Second, "how long to keep trying candidate tee times for a reservation")
+)
+
+// retryBackoff is how long to wait before retrying a transient failure.
+const retryBackoff = 500 * time.Millisecond
+
+var errBookDeadline = errors.New("booking deadline exceeded")
+
+// Outcome is the result of trying to reserve a single tee time.
+type Outcome string
+
+const (
+	OutcomeBooked    Outcome = "booked"
+	OutcomeSlotTaken Outcome = "slot taken"
+	OutcomeAuth      Outcome = "auth failed"
+	OutcomeTransient Outcome = "transient error"
+	OutcomeFailed    Outcome = "failed"
+)
+
+func outcomeOf(err error) Outcome {
+	if err == nil {
+		return OutcomeBooked
+	}
+	switch golfer.Classify(err) {
+	case golfer.ErrSlotTaken:
+		return OutcomeSlotTaken
+	case golfer.ErrAuth:
+		return OutcomeAuth
+	case golfer.ErrTransient:
+		return OutcomeTransient
+	default:
+		return OutcomeFailed
+	}
+}
+
+// Attempt records a single try at reserving a tee time.
+type Attempt struct {
+	Time    time.Time
+	TeeTime golfer.TeeTime
+	Outcome Outcome
+	Error   string `json:",omitempty"`
+	// Reservation is what was booked when Outcome is OutcomeBooked.
+	Reservation *golfer.Reservation `json:",omitempty"`
+}
+
+// Booking is a reservation flog made, kept as a history of what was booked,
+// when and for how much.
+type Booking struct {
+	Time      time.Time
+	PendingID string
+	ClubID    int
+	// Attempts is how many tee times were tried before this one was booked.
+	Attempts    int
+	Reservation golfer.Reservation
+}
+
+// Total returns the price of the booking.
+func (b Booking) Total() float64 {
+	return b.Reservation.Total()
+}
+
+// candidate is a tee time and the course it's on.
+type candidate struct {
+	course golfer.Course
+	tt     golfer.TeeTime
+}
+
+// fits returns whether a party of players can be booked into the tee time.
+func (c candidate) fits(players int) bool {
+	return !c.tt.Blocked && c.tt.FreeSlots >= players
+}
+
+// coursesFor returns the courses p may be booked on in order of preference.
+// Without any preference every course at the club that can be booked online
+// is acceptable. Courses that can't be played with p.Holes are skipped.
+func (s *server) coursesFor(g *golfer.Golfer, p PendingReservation) ([]golfer.Course, error) {
+	all, err := g.Courses(p.ClubID)
+	if err != nil {
+		return nil, err
+	}
+	var courses []golfer.Course
+	if len(p.CourseIDs) == 0 {
+		for _, c := range all {
+			if c.OnlineBookingEnabled && c.ValidateHoles(p.Holes) == nil {
+				courses = append(courses, c)
+			}
+		}
+	}
+	for _, id := range p.CourseIDs {
+		for _, c := range all {
+			if c.ID == id && c.ValidateHoles(p.Holes) == nil {
+				courses = append(courses, c)
+			}
+		}
+	}
+	if len(courses) == 0 {
+		return nil, errors.Errorf("no bookable courses found at club %d", p.ClubID)
+	}
+	return courses, nil
+}
+
+// rankedTeeTimes fetches the tee times for p on each course and ranks them,
+// full ones included. Earlier courses are preferred over later ones.
+func (s *server) rankedTeeTimes(g *golfer.Golfer, af golfer.Affiliation, courses []golfer.Course, p PendingReservation) ([]candidate, error) {
+	day, err := parseDate(p.Day)
+	if err != nil {
+		return nil, err
+	}
+	var ranked []candidate
+	for _, c := range courses {
+		tts, err := g.TeeTimes(c, day.Format(golfer.DayFormat), golfer.AffiliationTypeIDs(af, p.Players, p.party()...))
+		if err != nil {
+			return nil, err
+		}
+		tts, err = rankTeeTimes(p, tts)
+		if err != nil {
+			return nil, err
+		}
+		for _, tt := range tts {
+			ranked = append(ranked, candidate{c, tt})
+		}
+	}
+	return ranked, nil
+}
+
+// candidates returns the ranked tee times p can be booked into, skipping
+// ones that are blocked, don't have room for the party or were already tried.
+func (s *server) candidates(g *golfer.Golfer, af golfer.Affiliation, courses []golfer.Course, p PendingReservation, tried map[int]bool) ([]candidate, error) {
+	ranked, err := s.rankedTeeTimes(g, af, courses, p)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []candidate
+	for _, c := range ranked {
+		if c.fits(p.Players) && !tried[c.tt.ID] {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates, nil
+}
+
+// bookFirst walks the ranked tee times for p until one is reserved with g.
+// Taken slots fall through to the next candidate, auth failures log in again
+// and transient failures are retried, all within -book-deadline. The returned
+// run records what was considered and tried. It only talks to Chronogolf, so
+// s.mu shouldn't be held while it runs.
+func (s *server) bookFirst(g *golfer.Golfer, p PendingReservation) (Run, error) {
+	var run Run
+	af, err := g.Affiliation(p.ClubID)
+	if err != nil {
+		return run, err
+	}
+	courses, err := s.coursesFor(g, p)
+	if err != nil {
+		return run, err
+	}
+
+	tried := map[int]bool{}
+	candidates, err := s.candidates(g, af, courses, p, tried)
+	if err != nil {
+		return run, err
+	}
+	run.consider(candidates)
+
+	deadline := now().Add(*bookDeadline)
+	relogged := false
+	for len(candidates) > 0 {
+		if !now().Before(deadline) {
+			return run, errBookDeadline
+		}
+
+		c, tt := candidates[0].course, candidates[0].tt
+		log.Printf("reserving %+v on %s", tt, c.Name)
+		res, err := g.Reserve(af, c, tt, p.Players, p.Holes, p.party()...)
+		a := Attempt{
+			Time:    now(),
+			TeeTime: tt,
+			Outcome: outcomeOf(err),
+		}
+		if err != nil {
+			a.Error = err.Error()
+		} else {
+			a.Reservation = &res
+		}
+		run.Attempts = append(run.Attempts, a)
+		log.Printf("attempt %s %s on %s: %s %s", tt.Date, tt.StartTime, c.Name, a.Outcome, a.Error)
+
+		switch a.Outcome {
+		case OutcomeBooked:
+			return run, nil
+
+		case OutcomeSlotTaken:
+			tried[tt.ID] = true
+			if candidates, err = s.candidates(g, af, courses, p, tried); err != nil {
+				return run, err
+			}
+			run.consider(candidates)
+
+		case OutcomeAuth:
+			if relogged {
+				return run, err
+			}
+			relogged = true
+			if err := g.Relogin(); err != nil {
+				return run, err
+			}
+
+		case OutcomeTransient:
+			<-after(retryBackoff)
+
+		default:
+			return run, err
+		}
+	}
+	return run, errNoTeeTimes
+}
+
+// book runs bookFirst for u with s.mu, which must be held, released until it
+// returns. Anything read under the lock before calling it may have changed
+// since.
+func (s *server) book(u *User, p PendingReservation) (Run, error) {
+	g, err := u.golfer()
+	if err != nil {
+		return Run{}, err
+	}
+	s.mu.Unlock()
+	defer s.mu.Lock()
+	return s.bookFirst(g, p)
+}
+
+// attempt tries to book p for u and records the outcome on it and in the
+// history. s.mu must be held, p is attempting while it's released for the
+// booking.
+func (s *server) attempt(u *User, p *PendingReservation) (Run, error) {
+	return s.attemptAs(u, p, *p)
+}
+
+// attemptAs is attempt booking q in place of p, e.g. p narrowed down to a
+// single tee time.
+func (s *server) attemptAs(u *User, p *PendingReservation, q PendingReservation) (Run, error) {
+	p.setState(StateAttempting, nil)
+	run, err := s.book(u, q)
+	p.Attempts += len(run.Attempts)
+	s.record(u, *p, run, err)
+	if err != nil {
+		p.setState(StateFailed, err)
+		return run, err
+	}
+	s.booked(u, p, run)
+	return run, nil
+}
+
+var errNotBookable = errors.New("the day isn't open for booking yet, schedule the reservation instead")
+
+// bookNowResult is the outcome of booking a reservation straight away.
+type bookNowResult struct {
+	// Reservation is what was booked, if anything.
+	Reservation *golfer.Reservation `json:"reservation,omitempty"`
+	// Error is why nothing was booked.
+	Error string `json:"error,omitempty"`
+	// Pending is set if no tee time was free and the reservation was kept
+	// pending in case one opens up.
+	Pending  *PendingReservation `json:"pending,omitempty"`
+	Attempts []Attempt           `json:"attempts"`
+}
+
+// bookNow books pr for u before returning instead of leaving it to a booking
+// run, which only works once pr's day is open for booking. If no tee time is
+// free pr is kept pending, any other failure drops it. It returns the status
+// code to fail the request with if pr couldn't be tried at all.
+func (s *server) bookNow(u *User, pr *PendingReservation) (bookNowResult, int, error) {
+	result := bookNowResult{Attempts: []Attempt{}}
+	can, err := dateIsBookable(pr.Day)
+	if err != nil {
+		return result, 400, err
+	}
+	if !can {
+		return result, 400, errNotBookable
+	}
+	if u.hasRequest(pr) {
+		return result, 409, errors.New("reservation already exists")
+	}
+
+	u.Pending = append(u.Pending, pr)
+	run, err := s.attempt(u, pr)
+	result.Attempts = append(result.Attempts, run.Attempts...)
+	switch {
+	case err == nil:
+		result.Reservation = run.Booked()
+	case err == errNoTeeTimes:
+		result.Error = err.Error()
+		result.Pending = pr
+	default:
+		result.Error = err.Error()
+		u.removePending(pr)
+	}
+	if err := s.savePending(); err != nil {
+		return result, 500, errors.Wrap(err, "failed to save pending")
+	}
+	if result.Pending != nil {
+		s.wakeSniper()
+	}
+	return result, 0, nil
+}
+
+// booked marks p as booked and records the reservation run made in u's
+// booking history.
+func (s *server) booked(u *User, p *PendingReservation, run Run) {
+	p.setState(StateBooked, nil)
+	res := run.Booked()
+	if res == nil {
+		return
+	}
+	last := run.Attempts[len(run.Attempts)-1]
+	b := Booking{
+		Time:        last.Time,
+		PendingID:   p.ID,
+		ClubID:      p.ClubID,
+		Attempts:    len(run.Attempts),
+		Reservation: *res,
+	}
+	log.Printf("booked reservation %d for %s on %s %s, total $%.2f", b.Reservation.ID, u.Name, last.TeeTime.Date, last.TeeTime.StartTime, b.Total())
+	u.History = append(u.History, b)
+	if n := len(u.History) - maxHistory; n > 0 {
+		u.History = append([]Booking(nil), u.History[n:]...)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+func newTestServer(t *testing.T) (*server, *golfertest.Server) {
+	fake := golfertest.NewServer()
+	t.Cleanup(fake.Close)
+	golferOpts = []golfer.Option{golfer.WithBaseURL(fake.URL)}
+	t.Cleanup(func() {
+		golferOpts = nil
+	})
+	*saveFile = filepath.Join(t.TempDir(), "flog.data")
+	newFakeClock(time.Date(2018, 05, 10, 0, 0, 0, 0, time.Local))
+	s := &server{
+		Data: Data{DataFormatVersion: dataFormatVersion},
+		wake: make(chan struct{}, 1),
+	}
+	// Bookings started by requests mustn't outlive the test's temp dir.
+	t.Cleanup(s.background.Wait)
+	s.addUser(golfertest.User, golfer.Password(golfertest.Password))
+	if err := s.loadClubs(); err != nil {
+		t.Fatal(err)
+	}
+	return s, fake
+}
+
+// testGolfer returns u's Chronogolf client.
+func testGolfer(t *testing.T, u *User) *golfer.Golfer {
+	t.Helper()
+	g, err := u.golfer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// testHandler serves s's routes logged in as its first user, with the
+// session's CSRF token on every request.
+func testHandler(s *server) http.Handler {
+	s.mu.Lock()
+	s.Users[0].LoginHash = []byte("unused")
+	cookie := &http.Cookie{Name: sessionCookie, Value: s.newSession(s.Users[0].Name)}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	token := s.csrfToken(req)
+	s.mu.Unlock()
+	h := s.routes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.AddCookie(cookie)
+		r.Header.Set(csrfHeader, token)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func TestBookFirstFallsBack(t *testing.T) {
+	s, fake := newTestServer(t)
+	early := fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:00", 4)
+	target := fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:30", 4)
+
+	// Someone else grabs the closest tee time while we're booking it.
+	fake.OnReserve(func(id int) {
+		if id == target.ID {
+			fake.TakeSlots(id, 4)
+		}
+	})
+
+	run, err := s.bookFirst(testGolfer(t, s.Users[0]), PendingReservation{
+		ClubID:     golfertest.ClubID,
+		Day:        "2018-05-17T07:10",
+		Players:    2,
+		Earliest:   "07:00",
+		Latest:     "08:00",
+		Preference: PreferClosest,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := run.Attempts
+	if len(attempts) != 2 || attempts[0].Outcome != OutcomeSlotTaken || attempts[1].Outcome != OutcomeBooked {
+		t.Fatalf("attempts = %+v", attempts)
+	}
+	if attempts[1].TeeTime.ID != early.ID {
+		t.Errorf("booked %+v; not %+v", attempts[1].TeeTime, early)
+	}
+	reservations := fake.Reservations()
+	if len(reservations) != 1 || reservations[0].TeetimeID != early.ID {
+		t.Errorf("reservations = %+v", reservations)
+	}
+}
+
+func TestBookFirstRelogin(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+	fake.ExpireSessions()
+	fake.RotateCSRFToken()
+
+	run, err := s.bookFirst(testGolfer(t, s.Users[0]), PendingReservation{
+		ClubID:   golfertest.ClubID,
+		Day:      "2018-05-17T07:10",
+		Players:  1,
+		Earliest: "07:00",
+		Latest:   "08:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := run.Attempts
+	if len(attempts) != 2 || attempts[0].Outcome != OutcomeAuth || attempts[1].Outcome != OutcomeBooked {
+		t.Fatalf("attempts = %+v", attempts)
+	}
+}
+
+func TestAttemptBooking(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 0)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:20", 4)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-18", "12:00", 4)
+
+	booked := &PendingReservation{ID: "booked", ClubID: golfertest.ClubID, Day: "2018-05-17T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateWaiting}
+	full := &PendingReservation{ID: "full", ClubID: golfertest.ClubID, Day: "2018-05-18T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateWaiting}
+	later := &PendingReservation{ID: "later", ClubID: golfertest.ClubID, Day: "2018-05-19T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateWaiting}
+	s.Users[0].Pending = []*PendingReservation{booked, full, later}
+
+	s.attemptBooking()
+
+	if booked.State != StateBooked || booked.Attempts != 1 {
+		t.Errorf("booked = %+v", booked)
+	}
+	if full.State != StateFailed || full.LastError != errNoTeeTimes.Error() {
+		t.Errorf("full = %+v", full)
+	}
+	if later.State != StateWaiting || later.Attempts != 0 {
+		t.Errorf("later = %+v", later)
+	}
+	if len(s.Users[0].History) != 1 {
+		t.Fatalf("History = %+v", s.Users[0].History)
+	}
+	if b := s.Users[0].History[0]; b.PendingID != "booked" || b.Attempts != 1 || b.Reservation.Teetime.StartTime != "07:20" || b.Total() != 2*golfertest.Price {
+		t.Errorf("History[0] = %+v", b)
+	}
+
+	loaded := server{}
+	if err := loaded.loadPending(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Users) != 1 {
+		t.Fatalf("loaded users = %+v", loaded.Users)
+	}
+	if len(loaded.Users[0].Pending) != 3 || loaded.Users[0].Pending[0].State != StateBooked {
+		t.Errorf("loaded = %+v", loaded.Users[0].Pending)
+	}
+	if len(loaded.Users[0].History) != 1 || loaded.Users[0].History[0].Reservation.ID != s.Users[0].History[0].Reservation.ID {
+		t.Errorf("loaded history = %+v", loaded.Users[0].History)
+	}
+}
+
+func TestAttemptBookingCapsHistory(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:20", 4)
+
+	for i := 0; i < maxHistory; i++ {
+		s.Users[0].History = append(s.Users[0].History, Booking{PendingID: fmt.Sprint(i)})
+	}
+	s.Users[0].Pending = []*PendingReservation{
+		{ID: "booked", ClubID: golfertest.ClubID, Day: "2018-05-17T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateWaiting},
+	}
+
+	s.attemptBooking()
+
+	h := s.Users[0].History
+	if len(h) != maxHistory || h[0].PendingID != "1" || h[len(h)-1].PendingID != "booked" {
+		t.Errorf("History = %+v", h)
+	}
+	runs, err := readHistory(s.Users[0].Name, false, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Booked() == nil {
+		t.Errorf("runs = %+v", runs)
+	}
+}
+
+func TestBookFirstOtherClub(t *testing.T) {
+	s, fake := newTestServer(t)
+	const clubID = 20000
+	fake.AddClub(golfer.Club{ID: clubID, Name: "Other Club"})
+	fake.AddAffiliation(golfer.Affiliation{ID: 2, OrganizationID: clubID, OrganizationType: "Club", AffiliationTypeID: 30})
+	fake.AddAffiliationType(golfer.AffiliationType{ID: 30, ClubID: clubID, Name: "Member"})
+	fake.AddCourse(golfer.Course{ID: 2, Name: "Other", Holes: 18, ClubID: clubID, OnlineBookingEnabled: true})
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+	other := fake.AddTeeTime(2, "2018-05-17", "07:20", 4)
+	if err := s.Users[0].g.Relogin(); err != nil {
+		t.Fatal(err)
+	}
+
+	*clubIDs = fmt.Sprintf("%d,%d", golfertest.ClubID, clubID)
+	defer func() {
+		*clubIDs = strconv.Itoa(golfer.DefaultClubID)
+	}()
+	if err := s.loadClubs(); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := s.club(clubID); !ok || c.Name != "Other Club" {
+		t.Fatalf("club(%d) = %+v, %v", clubID, c, ok)
+	}
+
+	if _, err := s.bookFirst(testGolfer(t, s.Users[0]), PendingReservation{
+		ClubID:   clubID,
+		Day:      "2018-05-17T07:10",
+		Players:  2,
+		Earliest: "07:00",
+		Latest:   "08:00",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	reservations := fake.Reservations()
+	if len(reservations) != 1 || reservations[0].TeetimeID != other.ID || reservations[0].ClubID != clubID {
+		t.Errorf("reservations = %+v", reservations)
+	}
+}
+
+func TestBookFirstCoursePreference(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddCourse(golfer.Course{ID: 2, Name: "Executive", Holes: 9, ClubID: golfertest.ClubID, OnlineBookingEnabled: true})
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+	fake.AddTeeTime(2, "2018-05-17", "07:10", 0)
+	exec := fake.AddTeeTime(2, "2018-05-17", "07:50", 4)
+
+	run, err := s.bookFirst(testGolfer(t, s.Users[0]), PendingReservation{
+		ClubID:    golfertest.ClubID,
+		CourseIDs: []int{2, golfertest.CourseID},
+		Day:       "2018-05-17T07:10",
+		Players:   2,
+		Earliest:  "07:00",
+		Latest:    "08:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := run.Attempts
+	// The full tee time on the preferred course isn't worth trying.
+	if len(attempts) != 1 || attempts[0].TeeTime.ID != exec.ID {
+		t.Errorf("attempts = %+v", attempts)
+	}
+}
+
+func TestAttemptReleasesLock(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+	fake.OnReserve(func(int) {
+		locked := make(chan struct{})
+		go func() {
+			s.mu.Lock()
+			s.mu.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(5 * time.Second):
+			t.Error("s.mu is held while reserving")
+		}
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.Users[0]
+	p := &PendingReservation{
+		ClubID:   golfertest.ClubID,
+		Day:      "2018-05-17T07:10",
+		Players:  2,
+		Earliest: "07:00",
+		Latest:   "08:00",
+	}
+	u.Pending = append(u.Pending, p)
+	if _, err := s.attempt(u, p); err != nil {
+		t.Fatal(err)
+	}
+	if p.State != StateBooked || len(u.History) != 1 {
+		t.Errorf("State = %s, History = %+v", p.State, u.History)
+	}
+}
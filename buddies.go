@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/pkg/errors"
+)
+
+// Buddy is a saved player that can be added to pending reservations.
+type Buddy struct {
+	ID string
+	golfer.Player
+}
+
+// parseGuestsForm returns copies of the buddies checked in a form, so later
+// edits to the buddy list don't change existing reservations. Buddies keep
+// their affiliation type if it's one of the club's, unless the form picks
+// another in guest_type.{id}.
+func (s *server) parseGuestsForm(form url.Values, u *User, clubID, players int) ([]golfer.Player, error) {
+	var guests []golfer.Player
+	for _, id := range form["guest"] {
+		b := u.findBuddy(id)
+		if b == nil {
+			return nil, errors.Errorf("unknown buddy %q", id)
+		}
+		g := b.Player
+		if g.AffiliationTypeID != 0 && s.checkPlayerType(clubID, g, g.AffiliationTypeID) != nil {
+			g.AffiliationTypeID = 0
+		}
+		if v := form.Get("guest_type." + id); v != "" {
+			t, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid guest_type.%s value", id)
+			}
+			if err := s.checkPlayerType(clubID, g, t); err != nil {
+				return nil, err
+			}
+			g.AffiliationTypeID = t
+		}
+		guests = append(guests, g)
+	}
+	if len(guests) > players-1 {
+		return nil, errors.Errorf("%d guests don't fit in %d players", len(guests), players)
+	}
+	return guests, nil
+}
+
+func (s *server) handleBuddies(w http.ResponseWriter, r *http.Request, u *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		renderMarkdown(w, "buddies.md", struct {
+			Buddies     []*Buddy
+			CSRF        string
+			Clubs       []golfer.Club
+			GuestTypes  map[int][]golfer.AffiliationType
+			MemberTypes map[int][]golfer.AffiliationType
+			TypeNames   map[int]string
+		}{
+			Buddies:     u.Buddies,
+			CSRF:        s.csrfToken(r),
+			Clubs:       s.clubs,
+			GuestTypes:  s.guestTypes,
+			MemberTypes: s.memberTypes,
+			TypeNames:   s.affiliationTypeNames(),
+		})
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form: "+err.Error(), 400)
+			return
+		}
+		b := &Buddy{
+			ID: newID(),
+			Player: golfer.Player{
+				FirstName: strings.TrimSpace(r.FormValue("first")),
+				LastName:  strings.TrimSpace(r.FormValue("last")),
+				Email:     strings.TrimSpace(r.FormValue("email")),
+				Phone:     strings.TrimSpace(r.FormValue("phone")),
+				MemberNo:  strings.TrimSpace(r.FormValue("member")),
+			},
+		}
+		if v := r.FormValue("user"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "invalid user value: "+err.Error(), 400)
+				return
+			}
+			b.UserID = id
+		}
+		if v := r.FormValue("affiliation_type"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "invalid affiliation_type value: "+err.Error(), 400)
+				return
+			}
+			t, ok := s.affiliationType(id)
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown affiliation type %d", id), 400)
+				return
+			}
+			if err := s.checkPlayerType(t.ClubID, b.Player, id); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			b.AffiliationTypeID = id
+		}
+		if err := b.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		u.Buddies = append(u.Buddies, b)
+		if err := s.savePending(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to save buddies: %+v", err), 500)
+			return
+		}
+		http.Redirect(w, r, "/buddies", http.StatusSeeOther)
+
+	default:
+		http.Error(w, "must use get or post", 400)
+	}
+}
+
+// handleBuddy handles /buddies/{id}/delete.
+func (s *server) handleBuddy(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "must use post", 400)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/buddies/"), "/")
+	if len(parts) != 2 || parts[1] != "delete" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, b := range u.Buddies {
+		if b.ID == parts[0] {
+			u.Buddies = append(u.Buddies[:i], u.Buddies[i+1:]...)
+			if err := s.savePending(); err != nil {
+				http.Error(w, fmt.Sprintf("failed to save buddies: %+v", err), 500)
+				return
+			}
+			http.Redirect(w, r, "/buddies", http.StatusSeeOther)
+			return
+		}
+	}
+	http.Error(w, "unknown buddy", 404)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/pkg/errors"
+)
+
+var (
+	serverURL    = flag.String("server", "http://localhost:8080", "the flog server the pending, reservations and teetimes subcommands talk to")
+	loginPassEnv = flag.String("login-pass-env", "FLOG_PASSWORD", "the environment variable holding the flog password of -user for subcommands")
+)
+
+// stdout is where subcommands print so tests can capture it.
+var stdout io.Writer = os.Stdout
+
+// commands are flog's subcommands. Without one flog runs the server.
+var commands = map[string]func(args []string) error{
+	"pending":      cmdPending,
+	"reservations": cmdReservations,
+	"teetimes":     cmdTeeTimes,
+	"book-now":     cmdBookNow,
+}
+
+const commandUsage = `usage: flog [flags] [command]
+
+Without a command flog runs the server. Commands:
+
+  pending list                 list pending reservations
+  pending add --date ...       add a pending reservation
+  pending rm ID...             delete pending reservations
+  reservations                 list upcoming Chronogolf reservations
+  teetimes --date YYYY-MM-DD   list the tee times on a day
+  book-now --date ...          book the best tee time straight away
+
+pending, reservations and teetimes talk to the -server API, logging in as
+-user with the flog password in -login-pass-env. book-now books with
+Chronogolf directly using the credentials of -user.`
+
+func runCommand(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return errors.Errorf("unknown command %q\n\n%s", args[0], commandUsage)
+	}
+	return cmd(args[1:])
+}
+
+// apiClient talks to a running flog's JSON API.
+type apiClient struct {
+	base   string
+	client *http.Client
+	csrf   string
+}
+
+// newAPIClient logs into -server as -user.
+func newAPIClient() (*apiClient, error) {
+	if *username == "" {
+		return nil, errors.New("need -user to log into the flog server")
+	}
+	pass := os.Getenv(*loginPassEnv)
+	if pass == "" {
+		return nil, errors.Errorf("need the flog password of %s in $%s", *username, *loginPassEnv)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &apiClient{
+		base: strings.TrimSuffix(*serverURL, "/"),
+		client: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	resp, err := c.client.PostForm(c.base+"/login", url.Values{"email": {*username}, "password": {pass}})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		return nil, errors.Errorf("logging into %s as %s: %s", c.base, *username, resp.Status)
+	}
+	var session struct {
+		CSRF string `json:"csrf"`
+	}
+	if err := c.do("GET", "session", nil, &session); err != nil {
+		return nil, err
+	}
+	c.csrf = session.CSRF
+	return c, nil
+}
+
+// do sends body as JSON to the API endpoint at path and decodes the response
+// into out.
+func (c *apiClient) do(method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.base+apiPrefix+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(csrfHeader, c.csrf)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e apiError
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			e.Error = resp.Status
+		}
+		return errors.Errorf("%s %s: %s", method, path, e.Error)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// intsFlag is a comma separated list of IDs.
+type intsFlag []int
+
+func (f *intsFlag) String() string {
+	var parts []string
+	for _, id := range *f {
+		parts = append(parts, strconv.Itoa(id))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *intsFlag) Set(v string) error {
+	for _, field := range strings.Split(v, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return err
+		}
+		*f = append(*f, id)
+	}
+	return nil
+}
+
+// pendingFlags adds the fields of a new pending reservation to fs.
+func pendingFlags(fs *flag.FlagSet) *pendingRequest {
+	var req pendingRequest
+	fs.StringVar(&req.Date, "date", "", "the target tee time as YYYY-MM-DDTHH:MM")
+	fs.IntVar(&req.Players, "players", 1, "the number of players")
+	fs.StringVar(&req.Earliest, "earliest", "", "the earliest acceptable start time, defaults to -date's")
+	fs.StringVar(&req.Latest, "latest", "", "the latest acceptable start time, defaults to 23:59")
+	fs.StringVar(&req.Preference, "preference", "", "which tee time in the window to prefer: closest, earliest or latest")
+	fs.IntVar(&req.Club, "club", 0, "the club to book at, defaults to the first of the server's -clubs")
+	fs.Var((*intsFlag)(&req.Courses), "courses", "comma separated IDs of the acceptable courses in order of preference")
+	fs.IntVar(&req.Holes, "holes", 0, "the number of holes, defaults to the course's")
+	fs.BoolVar(&req.Watch, "watch", false, "keep polling for cancellations once the day is open")
+	return &req
+}
+
+func cmdPending(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: flog pending list|add|rm")
+	}
+	c, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		var pending []PendingReservation
+		if err := c.do("GET", "pending", nil, &pending); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tDAY\tPLAYERS\tWINDOW\tWATCH\tSTATE\tERROR")
+		for _, p := range pending {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s-%s %s\t%t\t%s\t%s\n", p.ID, p.Day, p.Players, p.Earliest, p.Latest, p.Preference, p.Watch, p.State, p.LastError)
+		}
+		return w.Flush()
+
+	case "add":
+		fs := flag.NewFlagSet("pending add", flag.ContinueOnError)
+		req := pendingFlags(fs)
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		var created PendingReservation
+		if err := c.do("POST", "pending", req, &created); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "added %s: %s for %d, %s\n", created.ID, created.Day, created.Players, created.State)
+		return nil
+
+	case "rm":
+		if len(args) == 1 {
+			return errors.New("usage: flog pending rm ID...")
+		}
+		for _, id := range args[1:] {
+			if err := c.do("DELETE", "pending/"+url.PathEscape(id), nil, nil); err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, "deleted %s\n", id)
+		}
+		return nil
+	}
+	return errors.Errorf("unknown pending command %q", args[0])
+}
+
+func cmdReservations(args []string) error {
+	c, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	var reservations []golfer.Reservation
+	if err := c.do("GET", "reservations", nil, &reservations); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDATE\tTIME\tPLAYERS\tHOLES\tTOTAL")
+	for _, r := range reservations {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t$%.2f\n", r.ID, r.Teetime.Date, r.Teetime.StartTime, len(r.Rounds), r.Holes, r.Total())
+	}
+	return w.Flush()
+}
+
+func cmdTeeTimes(args []string) error {
+	fs := flag.NewFlagSet("teetimes", flag.ContinueOnError)
+	date := fs.String("date", "", "the day to list as YYYY-MM-DD")
+	players := fs.Int("players", 1, "the number of players")
+	club := fs.Int("club", 0, "the club to list, defaults to the first of the server's -clubs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *date == "" {
+		return errors.New("usage: flog teetimes --date YYYY-MM-DD")
+	}
+	c, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	q := url.Values{"date": {*date}, "players": {strconv.Itoa(*players)}}
+	if *club != 0 {
+		q.Set("club", strconv.Itoa(*club))
+	}
+	var tts []apiTeeTime
+	if err := c.do("GET", "teetimes?"+q.Encode(), nil, &tts); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tCOURSE\tFREE")
+	for _, tt := range tts {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\n", tt.TeeTime.ID, tt.TeeTime.StartTime, tt.Course.Name, tt.TeeTime.FreeSlots)
+	}
+	return w.Flush()
+}
+
+// cmdBookNow books a tee time with Chronogolf straight away, without a
+// server, so it can be run from cron.
+func cmdBookNow(args []string) error {
+	fs := flag.NewFlagSet("book-now", flag.ContinueOnError)
+	req := pendingFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("need -user to book with")
+	}
+	creds, err := flagCredentials()
+	if err != nil {
+		return err
+	}
+	s := &server{}
+	u := s.addUser(*username, creds)
+	if err := s.loadClubs(); err != nil {
+		return err
+	}
+	p, err := s.parseReserveForm(req.form(), u)
+	if err != nil {
+		return err
+	}
+	can, err := dateIsBookable(p.Day)
+	if err != nil {
+		return err
+	}
+	if !can {
+		return errNotBookable
+	}
+	g, err := u.golfer()
+	if err != nil {
+		return err
+	}
+	run, err := s.bookFirst(g, *p)
+	if err != nil {
+		return err
+	}
+	res := run.Booked()
+	fmt.Fprintf(stdout, "booked %s %s for %d, reservation %d, $%.2f\n", res.Teetime.Date, res.Teetime.StartTime, len(res.Rounds), res.ID, res.Total())
+	return nil
+}
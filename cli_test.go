@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/flog/golfer/golfertest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// runTestCommand runs a subcommand and returns what it printed.
+func runTestCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	old := stdout
+	stdout = &out
+	defer func() { stdout = old }()
+	err := runCommand(args)
+	return out.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:00", 4)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:30", 4)
+	hash, err := bcrypt.GenerateFromPassword([]byte("flog password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Users[0].LoginHash = hash
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	oldServerURL, oldUsername := *serverURL, *username
+	*serverURL, *username = ts.URL, golfertest.User
+	defer func() { *serverURL, *username = oldServerURL, oldUsername }()
+
+	if _, err := runTestCommand(t, "pending", "list"); err == nil {
+		t.Errorf("pending list without a password succeeded")
+	}
+	t.Setenv(*loginPassEnv, "wrong")
+	if _, err := runTestCommand(t, "pending", "list"); err == nil {
+		t.Errorf("pending list with the wrong password succeeded")
+	}
+	t.Setenv(*loginPassEnv, "flog password")
+
+	out, err := runTestCommand(t, "pending", "add", "--date", "2018-05-25T07:10", "--players", "2", "--latest", "08:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "added ") {
+		t.Errorf("pending add printed %q", out)
+	}
+	if _, err := runTestCommand(t, "pending", "add", "--date", "2018-05-25T07:10", "--players", "9"); err == nil || !strings.Contains(err.Error(), "players") {
+		t.Errorf("pending add for 9 players = %v", err)
+	}
+	s.mu.Lock()
+	id := s.Users[0].Pending[0].ID
+	s.mu.Unlock()
+	if out, err := runTestCommand(t, "pending", "list"); err != nil || !strings.Contains(out, id) || !strings.Contains(out, "07:10-08:00") {
+		t.Errorf("pending list = %q, %v", out, err)
+	}
+	if _, err := runTestCommand(t, "pending", "rm", id); err != nil {
+		t.Error(err)
+	}
+	s.mu.Lock()
+	if len(s.Users[0].Pending) != 0 {
+		t.Errorf("pending after rm = %+v", s.Users[0].Pending)
+	}
+	s.mu.Unlock()
+	if _, err := runTestCommand(t, "pending", "rm", id); err == nil {
+		t.Errorf("removing %s twice succeeded", id)
+	}
+
+	if out, err := runTestCommand(t, "teetimes", "--date", "2018-05-17"); err != nil || !strings.Contains(out, "07:00") || !strings.Contains(out, "07:30") {
+		t.Errorf("teetimes = %q, %v", out, err)
+	}
+
+	oldPassword := *password
+	*password = golfertest.Password
+	defer func() { *password = oldPassword }()
+	if _, err := runTestCommand(t, "book-now", "--date", "2018-05-24T07:30", "--players", "2"); err != errNotBookable {
+		t.Errorf("book-now before the day opens = %v, want %v", err, errNotBookable)
+	}
+	out, err = runTestCommand(t, "book-now", "--date", "2018-05-17T07:30", "--players", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "booked 2018-05-17 07:30 for 2") {
+		t.Errorf("book-now printed %q", out)
+	}
+	if out, err := runTestCommand(t, "reservations"); err != nil || !strings.Contains(out, "07:30") {
+		t.Errorf("reservations = %q, %v", out, err)
+	}
+
+	if _, err := runTestCommand(t, "explode"); err == nil {
+		t.Errorf("unknown command succeeded")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/pkg/errors"
+)
+
+var (
+	passEnv        = flag.String("pass-env", "", "the environment variable holding the Chronogolf password of the -user account")
+	passFile       = flag.String("pass-file", "", "a file only you can read holding the Chronogolf password of the -user account")
+	secretsFile    = flag.String("secrets", "", "an encrypted file of Chronogolf passwords by email, used for accounts without another password")
+	secretsPassEnv = flag.String("secrets-passphrase-env", "FLOG_SECRETS_PASSPHRASE", "the environment variable holding the passphrase of the -secrets file")
+	addSecret      = flag.Bool("add-secret", false, "read the Chronogolf password of the -user account from stdin into the -secrets file and exit")
+)
+
+func secretsPassphrase() golfer.Credentials {
+	return golfer.EnvPassword(*secretsPassEnv)
+}
+
+func secretsCredentials(user string) golfer.Credentials {
+	return golfer.SecretsFile{
+		Path:       *secretsFile,
+		User:       user,
+		Passphrase: secretsPassphrase(),
+	}
+}
+
+// flagCredentials returns the credentials of the -user account, or nil if
+// none were given.
+func flagCredentials() (golfer.Credentials, error) {
+	var creds []golfer.Credentials
+	if *password != "" {
+		log.Printf("-pass can be seen by anyone who can list processes, use -pass-env, -pass-file or -secrets instead")
+		creds = append(creds, golfer.Password(*password))
+	}
+	if *passEnv != "" {
+		creds = append(creds, golfer.EnvPassword(*passEnv))
+	}
+	if *passFile != "" {
+		creds = append(creds, golfer.FilePassword(*passFile))
+	}
+	switch {
+	case len(creds) > 1:
+		return nil, errors.New("only one of -pass, -pass-env and -pass-file can be used")
+	case len(creds) == 1:
+		return creds[0], nil
+	case *secretsFile != "":
+		return secretsCredentials(*username), nil
+	}
+	return nil, nil
+}
+
+// storeSecret saves the Chronogolf password of user to the -secrets file.
+func storeSecret(user, pass string) error {
+	passphrase, err := secretsPassphrase().Password()
+	if err != nil {
+		return errors.Wrapf(err, "unlocking %s", *secretsFile)
+	}
+	secrets, err := golfer.ReadSecrets(*secretsFile, passphrase)
+	if os.IsNotExist(errors.Cause(err)) {
+		secrets = map[string]string{}
+	} else if err != nil {
+		return err
+	}
+	for name := range secrets {
+		if strings.EqualFold(name, user) {
+			delete(secrets, name)
+		}
+	}
+	secrets[user] = pass
+	return golfer.WriteSecrets(*secretsFile, passphrase, secrets)
+}
+
+// addSecretFromStdin is -add-secret.
+func addSecretFromStdin() error {
+	if *username == "" || *secretsFile == "" {
+		return errors.New("-add-secret needs -user and -secrets")
+	}
+	fmt.Fprintf(os.Stderr, "Chronogolf password for %s: ", *username)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+	pass := strings.TrimRight(line, "\r\n")
+	if pass == "" {
+		return errors.New("empty password")
+	}
+	return storeSecret(*username, pass)
+}
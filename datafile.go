@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// dataFileMode is the mode of the data file, its backup and the history file.
+// They hold Chronogolf passwords, the session key and the user's bookings so
+// only flog's user can read them.
+const dataFileMode = 0600
+
+// backupPath is where the last good copy of the data file at path is kept.
+func backupPath(path string) string {
+	return path + ".bak"
+}
+
+// writeFileAtomic replaces path with b. b is written to a temporary file
+// next to path and synced before being renamed over it, so a crash leaves
+// either the old or the new file and never a partial one.
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(dataFileMode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// writeDataFile saves b as the data file at path, first rolling the current
+// one into the backup if it's intact.
+func writeDataFile(path string, b []byte) error {
+	old, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && json.Valid(old) && !bytes.Equal(old, b) {
+		if err := writeFileAtomic(backupPath(path), old); err != nil {
+			return errors.Wrap(err, "backing up data file")
+		}
+	}
+	return writeFileAtomic(path, b)
+}
+
+// readDataFile reads the data file at path, falling back to the backup if the
+// data file is missing or corrupt. It returns nil if neither exists.
+func readDataFile(path string) (map[string]interface{}, error) {
+	data, err := decodeDataFile(path)
+	if err == nil {
+		return data, nil
+	}
+	backup, backupErr := decodeDataFile(backupPath(path))
+	switch {
+	case os.IsNotExist(err) && os.IsNotExist(backupErr):
+		log.Printf("Save file doesn't exist.")
+		return nil, nil
+	case backupErr != nil:
+		if os.IsNotExist(err) {
+			return nil, errors.Wrapf(backupErr, "reading backup %s", backupPath(path))
+		}
+		return nil, errors.Wrapf(err, "reading %s with no usable backup", path)
+	}
+	log.Printf("Failed to read %s, recovering from %s: %v", path, backupPath(path), err)
+	return backup, nil
+}
+
+func decodeDataFile(path string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, errors.Wrapf(err, "decoding %s", path)
+	}
+	if data == nil {
+		return nil, errors.Errorf("%s is empty", path)
+	}
+	return data, nil
+}
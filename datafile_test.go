@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDataFile(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Users[0].Pending = []*PendingReservation{{ID: "first"}}
+	if err := s.savePending(); err != nil {
+		t.Fatal(err)
+	}
+	s.Users[0].Pending = append(s.Users[0].Pending, &PendingReservation{ID: "second"})
+	if err := s.savePending(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{*saveFile, backupPath(*saveFile)} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != dataFileMode {
+			t.Errorf("%s mode = %v", path, fi.Mode())
+		}
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(*saveFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Errorf("left behind files %v", names)
+	}
+
+	var loaded server
+	if err := loaded.loadPending(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Users) != 1 || len(loaded.Users[0].Pending) != 2 {
+		t.Fatalf("loaded = %+v", loaded.Users)
+	}
+
+	// A torn write falls back to the backup.
+	if err := ioutil.WriteFile(*saveFile, []byte(`{"DataFormatVer`), dataFileMode); err != nil {
+		t.Fatal(err)
+	}
+	var recovered server
+	if err := recovered.loadPending(); err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered.Users) != 1 || len(recovered.Users[0].Pending) != 1 || recovered.Users[0].Pending[0].ID != "first" {
+		t.Fatalf("recovered = %+v", recovered.Users)
+	}
+	// Saving again doesn't back up the corrupt file.
+	if err := recovered.savePending(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*saveFile, nil, dataFileMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&server{}).loadPending(); err != nil {
+		t.Errorf("loading with a good backup = %v", err)
+	}
+
+	if err := ioutil.WriteFile(backupPath(*saveFile), nil, dataFileMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&server{}).loadPending(); err == nil {
+		t.Errorf("loading with a corrupt data file and backup succeeded")
+	}
+
+	if err := ioutil.WriteFile(*saveFile, []byte(`{"DataFormatVersion":99}`), dataFileMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&server{}).loadPending(); err == nil {
+		t.Errorf("loading a newer data file succeeded")
+	}
+}
@@ -0,0 +1,209 @@
+package golfer
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Credentials provides the password of a Chronogolf account. It's asked for
+// the password every time the Golfer logs in, so passwords can be rotated
+// without restarting.
+type Credentials interface {
+	Password() (string, error)
+}
+
+// Password is a password that's known up front.
+type Password string
+
+func (p Password) Password() (string, error) {
+	if p == "" {
+		return "", errors.New("empty password")
+	}
+	return string(p), nil
+}
+
+// EnvPassword reads the password from the environment variable it names.
+type EnvPassword string
+
+func (e EnvPassword) Password() (string, error) {
+	p := os.Getenv(string(e))
+	if p == "" {
+		return "", errors.Errorf("environment variable %s isn't set", string(e))
+	}
+	return p, nil
+}
+
+// FilePassword reads the password from the file it names. The file must not
+// be readable by its group or other users.
+type FilePassword string
+
+func (f FilePassword) Password() (string, error) {
+	b, err := readSecret(string(f))
+	if err != nil {
+		return "", err
+	}
+	p := strings.TrimSpace(string(b))
+	if p == "" {
+		return "", errors.Errorf("%s is empty", string(f))
+	}
+	return p, nil
+}
+
+// SecretsFile reads the password of User from an encrypted secrets file
+// written by WriteSecrets.
+type SecretsFile struct {
+	Path string
+	User string
+	// Passphrase unlocks the file.
+	Passphrase Credentials
+}
+
+func (s SecretsFile) Password() (string, error) {
+	passphrase, err := s.Passphrase.Password()
+	if err != nil {
+		return "", errors.Wrapf(err, "unlocking %s", s.Path)
+	}
+	secrets, err := ReadSecrets(s.Path, passphrase)
+	if err != nil {
+		return "", err
+	}
+	for user, p := range secrets {
+		if strings.EqualFold(user, s.User) {
+			return p, nil
+		}
+	}
+	return "", errors.Errorf("%s has no password for %s", s.Path, s.User)
+}
+
+const (
+	secretsMagic   = "flog secrets v1\n"
+	secretsSaltLen = 16
+	secretsKeyLen  = 32
+	secretsNonce   = 24
+)
+
+// secretsKey derives the key of a secrets file from its passphrase.
+func secretsKey(passphrase string, salt []byte) (*[secretsKeyLen]byte, error) {
+	b, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, secretsKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	var key [secretsKeyLen]byte
+	copy(key[:], b)
+	return &key, nil
+}
+
+// ReadSecrets decrypts a secrets file, returning the passwords in it by
+// Chronogolf email.
+func ReadSecrets(path, passphrase string) (map[string]string, error) {
+	b, err := readSecret(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(string(b), secretsMagic) || len(b) < len(secretsMagic)+secretsSaltLen+secretsNonce {
+		return nil, errors.Errorf("%s isn't a secrets file", path)
+	}
+	b = b[len(secretsMagic):]
+	salt, b := b[:secretsSaltLen], b[secretsSaltLen:]
+	var nonce [secretsNonce]byte
+	copy(nonce[:], b)
+	key, err := secretsKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, ok := secretbox.Open(nil, b[secretsNonce:], &nonce, key)
+	if !ok {
+		return nil, errors.Errorf("wrong passphrase for %s", path)
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, errors.Wrapf(err, "decoding %s", path)
+	}
+	return secrets, nil
+}
+
+// WriteSecrets encrypts secrets, passwords by Chronogolf email, into a
+// secrets file only the current user can read.
+func WriteSecrets(path, passphrase string, secrets map[string]string) error {
+	if passphrase == "" {
+		return errors.New("need a passphrase to encrypt secrets with")
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, secretsSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	var nonce [secretsNonce]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	key, err := secretsKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	out := append([]byte(secretsMagic), salt...)
+	out = append(out, nonce[:]...)
+	out = secretbox.Seal(out, plain, &nonce, key)
+	return writePrivate(path, out)
+}
+
+// writePrivate replaces path with b in a file only the current user can read.
+// It's written to a temporary file that's synced and renamed over path, so a
+// crash mid-write can't lose the secrets that were there.
+func writePrivate(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// readSecret reads a file holding secrets, refusing ones its group or other
+// users can read.
+func readSecret(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0044 != 0 {
+		return nil, errors.Errorf("%s can be read by other users, chmod 600 it", path)
+	}
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,93 @@
+package golfer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+func TestCredentials(t *testing.T) {
+	dir := t.TempDir()
+
+	os.Setenv("FLOG_TEST_PASS", "from env")
+	defer os.Unsetenv("FLOG_TEST_PASS")
+	if p, err := golfer.EnvPassword("FLOG_TEST_PASS").Password(); err != nil || p != "from env" {
+		t.Errorf("EnvPassword = %q, %v", p, err)
+	}
+	if _, err := golfer.EnvPassword("FLOG_TEST_UNSET").Password(); err == nil {
+		t.Errorf("EnvPassword of an unset variable succeeded")
+	}
+
+	path := filepath.Join(dir, "pass")
+	if err := ioutil.WriteFile(path, []byte("from file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := golfer.FilePassword(path).Password(); err == nil {
+		t.Errorf("FilePassword of a world readable file succeeded")
+	}
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := golfer.FilePassword(path).Password(); err == nil {
+		t.Errorf("FilePassword of a group readable file succeeded")
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := golfer.FilePassword(path).Password(); err != nil || p != "from file" {
+		t.Errorf("FilePassword = %q, %v", p, err)
+	}
+}
+
+func TestSecretsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+	secrets := map[string]string{golfertest.User: golfertest.Password}
+	if err := golfer.WriteSecrets(path, "open sesame", secrets); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("secrets file mode = %v, %v", fi.Mode(), err)
+	}
+	if _, err := golfer.ReadSecrets(path, "wrong"); err == nil {
+		t.Errorf("ReadSecrets with the wrong passphrase succeeded")
+	}
+
+	creds := golfer.SecretsFile{Path: path, User: "Golfer@Example.com", Passphrase: golfer.Password("open sesame")}
+	fake := golfertest.NewServer()
+	defer fake.Close()
+	g, err := golfer.New(golfertest.User, creds, golfer.WithBaseURL(fake.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Passwords are read again on every login.
+	secrets[golfertest.User] = "rotated"
+	if err := golfer.WriteSecrets(path, "open sesame", secrets); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Relogin(); err == nil {
+		t.Errorf("Relogin with a rotated password succeeded")
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := creds.Password(); err == nil {
+		t.Errorf("SecretsFile of a world readable file succeeded")
+	}
+
+	// Rewriting the file makes it private again without leaving anything
+	// behind.
+	if err := golfer.WriteSecrets(path, "open sesame", secrets); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("rewritten secrets file mode = %v, %v", fi.Mode(), err)
+	}
+	if files, err := ioutil.ReadDir(filepath.Dir(path)); err != nil || len(files) != 1 {
+		t.Errorf("files next to the secrets = %v, %v", files, err)
+	}
+}
@@ -0,0 +1,74 @@
+package golfer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoOptions is returned when a tee time has no reservation options, which
+// happens once it's been booked by someone else.
+var ErrNoOptions = errors.New("no options")
+
+// StatusError is returned when Chronogolf responds with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("got status %q: %q", e.Status, e.Body)
+}
+
+// ErrorKind is a coarse classification of request failures.
+type ErrorKind int
+
+const (
+	// ErrUnknown errors shouldn't be retried.
+	ErrUnknown ErrorKind = iota
+	// ErrSlotTaken means the tee time is no longer available.
+	ErrSlotTaken
+	// ErrAuth means the session or CSRF token is no longer valid.
+	ErrAuth
+	// ErrTransient errors may succeed if retried.
+	ErrTransient
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrSlotTaken:
+		return "slot taken"
+	case ErrAuth:
+		return "auth"
+	case ErrTransient:
+		return "transient"
+	default:
+		return "unknown"
+	}
+}
+
+// Classify returns the kind of err.
+func Classify(err error) ErrorKind {
+	err = errors.Cause(err)
+	if err == ErrNoOptions {
+		return ErrSlotTaken
+	}
+	if se, ok := err.(*StatusError); ok {
+		switch {
+		case se.StatusCode == http.StatusUnauthorized, se.StatusCode == http.StatusForbidden:
+			return ErrAuth
+		case se.StatusCode == http.StatusConflict, se.StatusCode == http.StatusUnprocessableEntity:
+			return ErrSlotTaken
+		case se.StatusCode == http.StatusRequestTimeout, se.StatusCode == http.StatusTooManyRequests, se.StatusCode >= 500:
+			return ErrTransient
+		}
+		return ErrUnknown
+	}
+	if _, ok := err.(net.Error); ok {
+		return ErrTransient
+	}
+	return ErrUnknown
+}
@@ -0,0 +1,40 @@
+package golfer
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestClassify(t *testing.T) {
+	status := func(code int) error {
+		return errors.Wrap(&StatusError{StatusCode: code, Status: http.StatusText(code)}, "reserving")
+	}
+	cases := []struct {
+		err  error
+		want ErrorKind
+	}{
+		{status(http.StatusUnauthorized), ErrAuth},
+		{status(http.StatusForbidden), ErrAuth},
+		{status(http.StatusConflict), ErrSlotTaken},
+		{status(http.StatusUnprocessableEntity), ErrSlotTaken},
+		{errors.Wrap(ErrNoOptions, "reserving"), ErrSlotTaken},
+		{status(http.StatusRequestTimeout), ErrTransient},
+		{status(http.StatusTooManyRequests), ErrTransient},
+		{status(http.StatusInternalServerError), ErrTransient},
+		{status(http.StatusBadGateway), ErrTransient},
+		{status(http.StatusServiceUnavailable), ErrTransient},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ErrTransient},
+		{errors.Wrap(&net.DNSError{Err: "timeout", IsTimeout: true}, "fetching"), ErrTransient},
+		{status(http.StatusBadRequest), ErrUnknown},
+		{status(http.StatusNotFound), ErrUnknown},
+		{errors.New("something else"), ErrUnknown},
+	}
+	for _, c := range cases {
+		if got := Classify(c.err); got != c.want {
+			t.Errorf("Classify(%v) = %s; not %s", c.err, got, c.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package golfer
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -10,7 +11,8 @@ import (
 	"net/http/cookiejar"
 	"net/http/httputil"
 	"regexp"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -21,37 +23,76 @@ import (
 const (
 	userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/65.0.3325.181 Safari/537.36"
 
-	courseID               = "17078"
-	origin                 = "https://www.chronogolf.com"
-	home                   = "https://www.chronogolf.com/en/club/" + courseID + "/widget?medium=widget&source=club"
-	sessionAPI             = "https://www.chronogolf.com/private_api/sessions"
-	courseAPI              = "https://www.chronogolf.com/private_api/clubs/" + courseID + "/courses"
-	reservationAPI         = "https://www.chronogolf.com/private_api/reservations"
-	reservationUpcomingAPI = "https://www.chronogolf.com/private_api/users/%d/reservations?page=1&per_page=1000&status=upcoming&user_id=%d"
-	teetimeAPI             = "https://www.chronogolf.com/private_api/teetimes?affiliation_type_ids=%s&date=%s&course_id=%d"
-	reservationOptionsAPI  = "https://www.chronogolf.com/private_api/reservations/options?affiliation_type_ids=%s&teetime_id=%d&nb_holes=%d"
+	// DefaultBaseURL is where Chronogolf is served from. All of the paths
+	// below are relative to it.
+	DefaultBaseURL = "https://www.chronogolf.com"
+
+	// DefaultClubID is the club whose widget is used to start a session
+	// unless WithClub is passed.
+	DefaultClubID = 17078
+
+	home                   = "/en/club/%d/widget?medium=widget&source=club"
+	sessionAPI             = "/private_api/sessions"
+	clubAPI                = "/private_api/clubs/%d"
+	courseAPI              = "/private_api/clubs/%d/courses"
+	affiliationTypeAPI     = "/private_api/clubs/%d/affiliation_types"
+	reservationAPI         = "/private_api/reservations"
+	reservationCancelAPI   = "/private_api/reservations/%d/cancel"
+	reservationUpcomingAPI = "/private_api/users/%d/reservations?page=1&per_page=1000&status=upcoming&user_id=%d"
+	teetimeAPI             = "/private_api/teetimes?affiliation_type_ids=%s&date=%s&course_id=%d"
+	reservationOptionsAPI  = "/private_api/reservations/options?affiliation_type_ids=%s&teetime_id=%d&nb_holes=%d"
 
 	loginEvery = 24 * time.Hour
 )
 
 type Golfer struct {
-	client *http.Client
+	client  *http.Client
+	baseURL string
+	clubID  int
 
-	user, pass string
+	user  string
+	creds Credentials
 
+	// mu guards the session below so a Golfer can be shared between
+	// concurrent requests.
+	mu           sync.Mutex
 	lastLoggedIn time.Time
 	appConfig    AppConfig
 	userSession  SessionResponse
 }
 
-func New(user, pass string) (*Golfer, error) {
-	if len(user) == 0 || len(pass) == 0 {
-		return nil, errors.Errorf("need to specify -user, -pass")
+// Option configures a Golfer.
+type Option func(g *Golfer)
+
+// WithBaseURL talks to a Chronogolf at a different address, such as a
+// golfertest.Server.
+func WithBaseURL(url string) Option {
+	return func(g *Golfer) {
+		g.baseURL = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithClub starts the session from a different club's booking widget.
+func WithClub(clubID int) Option {
+	return func(g *Golfer) {
+		g.clubID = clubID
+	}
+}
+
+// New logs into Chronogolf as user with the password from creds.
+func New(user string, creds Credentials, opts ...Option) (*Golfer, error) {
+	if len(user) == 0 || creds == nil {
+		return nil, errors.Errorf("need to specify a user and their credentials")
 	}
 
 	g := Golfer{
-		user: user,
-		pass: pass,
+		baseURL: DefaultBaseURL,
+		clubID:  DefaultClubID,
+		user:    user,
+		creds:   creds,
+	}
+	for _, opt := range opts {
+		opt(&g)
 	}
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	if err != nil {
@@ -73,24 +114,27 @@ func New(user, pass string) (*Golfer, error) {
 	return &g, nil
 }
 
-func (g *Golfer) newRequest(method, url string, body io.Reader) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, body)
+func (g *Golfer) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, g.baseURL+path, body)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Referer", home)
-	req.Header.Set("Origin", origin)
-	if g.appConfig.CSRFToken != "" {
-		req.Header.Set("X-CSRF-Token", g.appConfig.CSRFToken)
+	req.Header.Set("Referer", g.baseURL+fmt.Sprintf(home, g.clubID))
+	req.Header.Set("Origin", g.baseURL)
+	g.mu.Lock()
+	token := g.appConfig.CSRFToken
+	g.mu.Unlock()
+	if token != "" {
+		req.Header.Set("X-CSRF-Token", token)
 	}
 
 	return req, nil
 }
 
-func (g *Golfer) getJSON(url string, respBody interface{}) error {
-	req, err := g.newRequest("GET", url, nil)
+func (g *Golfer) getJSON(path string, respBody interface{}) error {
+	req, err := g.newRequest("GET", path, nil)
 	if err != nil {
 		return err
 	}
@@ -109,7 +153,7 @@ func (g *Golfer) getJSON(url string, respBody interface{}) error {
 		if err != nil {
 			return err
 		}
-		return errors.Errorf("got status %q: %q", resp.Status, body)
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(respBody); err != nil {
@@ -126,19 +170,27 @@ func dumpRequest(req *http.Request) {
 	log.Println(string(requestDump))
 }
 
-func (g *Golfer) postJSON(url string, reqBody, respBody interface{}) error {
+func (g *Golfer) postJSON(path string, reqBody, respBody interface{}) error {
+	return g.sendJSON("POST", path, reqBody, respBody)
+}
+
+func (g *Golfer) putJSON(path string, reqBody, respBody interface{}) error {
+	return g.sendJSON("PUT", path, reqBody, respBody)
+}
+
+func (g *Golfer) sendJSON(method, path string, reqBody, respBody interface{}) error {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(reqBody); err != nil {
 		return err
 	}
-	req, err := g.newRequest("POST", url, &buf)
+	req, err := g.newRequest(method, path, &buf)
 	if err != nil {
 		return err
 	}
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 
-	log.Printf("POST: %q", req.URL)
+	log.Printf("%s: %q", method, req.URL)
 	resp, err := g.client.Do(req)
 	if err != nil {
 		return err
@@ -150,7 +202,7 @@ func (g *Golfer) postJSON(url string, reqBody, respBody interface{}) error {
 		if err != nil {
 			return err
 		}
-		return errors.Errorf("got status %q: %q", resp.Status, body)
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(respBody); err != nil {
@@ -180,7 +232,7 @@ var configRegex = regexp.MustCompile(
 )
 
 func (g *Golfer) getConfig() error {
-	req, err := g.newRequest("GET", home, nil)
+	req, err := g.newRequest("GET", fmt.Sprintf(home, g.clubID), nil)
 	if err != nil {
 		return err
 	}
@@ -206,9 +258,13 @@ func (g *Golfer) getConfig() error {
 		if len(match) != 2 {
 			continue
 		}
-		if err := json.NewDecoder(bytes.NewReader(match[1])).Decode(&g.appConfig); err != nil {
+		var config AppConfig
+		if err := json.NewDecoder(bytes.NewReader(match[1])).Decode(&config); err != nil {
 			return err
 		}
+		g.mu.Lock()
+		g.appConfig = config
+		g.mu.Unlock()
 		found = true
 		break
 	}
@@ -261,10 +317,19 @@ func (g *Golfer) session() (*SessionResponse, error) {
 	if err := g.getJSON(sessionAPI, &resp); err != nil {
 		return nil, err
 	}
+	g.mu.Lock()
 	g.userSession = resp
+	g.mu.Unlock()
 	return &resp, nil
 }
 
+// currentSession returns the session of the logged in user.
+func (g *Golfer) currentSession() SessionResponse {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.userSession
+}
+
 type Course struct {
 	ID            int         `json:"id"`
 	Position      interface{} `json:"position"`
@@ -287,8 +352,54 @@ type Course struct {
 	DefaultProductID     int   `json:"default_product_id"`
 }
 
-func (g *Golfer) Course() (Course, error) {
-	courses, err := g.Courses()
+// HoleOptions returns the number of holes that can be booked on the course.
+// 18 hole courses can also be played as 9 and double rounds go around twice.
+func (c Course) HoleOptions() []int {
+	opts := []int{c.Holes}
+	if c.Holes == 18 {
+		opts = append(opts, 9)
+	}
+	if c.AllowDoubleRound {
+		opts = append(opts, c.Holes*2)
+	}
+	return opts
+}
+
+// ValidateHoles checks that holes can be booked on the course. Zero means the
+// course's default.
+func (c Course) ValidateHoles(holes int) error {
+	if holes == 0 {
+		return nil
+	}
+	for _, h := range c.HoleOptions() {
+		if h == holes {
+			return nil
+		}
+	}
+	return errors.Errorf("can't book %d holes on %s, options are %v", holes, c.Name, c.HoleOptions())
+}
+
+type Club struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	City     string `json:"city"`
+	Province string `json:"province"`
+	Country  string `json:"country"`
+	Currency string `json:"currency"`
+}
+
+func (g *Golfer) Club(clubID int) (Club, error) {
+	var resp Club
+	if err := g.getJSON(fmt.Sprintf(clubAPI, clubID), &resp); err != nil {
+		return Club{}, err
+	}
+	return resp, nil
+}
+
+// Course returns the first course at a club.
+func (g *Golfer) Course(clubID int) (Course, error) {
+	courses, err := g.Courses(clubID)
 	if err != nil {
 		return Course{}, err
 	}
@@ -298,29 +409,68 @@ func (g *Golfer) Course() (Course, error) {
 	return courses[0], nil
 }
 
-func (g *Golfer) Courses() ([]Course, error) {
+func (g *Golfer) Courses(clubID int) ([]Course, error) {
 	var resp []Course
-	if err := g.getJSON(courseAPI, &resp); err != nil {
+	if err := g.getJSON(fmt.Sprintf(courseAPI, clubID), &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// AffiliationType is a pricing category at a club, e.g. members or the
+// public rate guests pay.
+type AffiliationType struct {
+	ID     int    `json:"id"`
+	ClubID int    `json:"club_id"`
+	Name   string `json:"name"`
+	// Public types can be booked by anyone, members or not.
+	Public bool `json:"public"`
+}
+
+// AffiliationTypes returns the affiliation types a club offers.
+func (g *Golfer) AffiliationTypes(clubID int) ([]AffiliationType, error) {
+	var resp []AffiliationType
+	if err := g.getJSON(fmt.Sprintf(affiliationTypeAPI, clubID), &resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
-func (g *Golfer) Affiliation() (Affiliation, error) {
+// Affiliation returns the user's affiliation with a club.
+func (g *Golfer) Affiliation(clubID int) (Affiliation, error) {
 	if err := g.ensureLoggedIn(); err != nil {
 		return Affiliation{}, err
 	}
 
-	for _, a := range g.userSession.Affiliations {
-		if strconv.Itoa(a.OrganizationID) == courseID {
+	for _, a := range g.currentSession().Affiliations {
+		if a.OrganizationID == clubID {
 			return a, nil
 		}
 	}
-	return Affiliation{}, errors.New("can't find any matching affiliations")
+	return Affiliation{}, errors.Errorf("can't find any affiliations with club %d", clubID)
+}
+
+// Relogin refreshes the CSRF token and logs in again, e.g. after a request
+// was rejected as unauthorized.
+func (g *Golfer) Relogin() error {
+	if err := g.getConfig(); err != nil {
+		return err
+	}
+	_, err := g.login()
+	return err
+}
+
+// EnsureLoggedIn logs in if the current session is stale. It can be called
+// ahead of time sensitive requests so they don't pay for a login.
+func (g *Golfer) EnsureLoggedIn() error {
+	return g.ensureLoggedIn()
 }
 
 func (g *Golfer) ensureLoggedIn() error {
-	if time.Since(g.lastLoggedIn) > loginEvery {
+	g.mu.Lock()
+	last := g.lastLoggedIn
+	g.mu.Unlock()
+	if time.Since(last) > loginEvery {
 		if _, err := g.login(); err != nil {
 			return err
 		}
@@ -329,25 +479,23 @@ func (g *Golfer) ensureLoggedIn() error {
 }
 
 func (g *Golfer) login() (*SessionResponse, error) {
+	pass, err := g.creds.Password()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting the password of %s", g.user)
+	}
 	req := LoginRequest{
 		Session: Session{
 			Email:    g.user,
-			Password: g.pass,
+			Password: pass,
 		},
 	}
 	var resp SessionResponse
 	if err := g.postJSON(sessionAPI, req, &resp); err != nil {
 		return nil, err
 	}
+	g.mu.Lock()
 	g.lastLoggedIn = time.Now()
 	g.userSession = resp
+	g.mu.Unlock()
 	return &resp, nil
 }
-
-func repeatString(str string, times int) []string {
-	var a []string
-	for i := 0; i < times; i++ {
-		a = append(a, str)
-	}
-	return a
-}
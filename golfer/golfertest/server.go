@@ -0,0 +1,662 @@
+// Package golfertest provides a fake Chronogolf server for tests.
+package golfertest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/d4l3k/flog/golfer"
+)
+
+const (
+	// User and Password are the credentials of the fake's only user.
+	User     = "golfer@example.com"
+	Password = "hunter2"
+	UserID   = 1
+
+	ClubID                  = 17078
+	CourseID                = 1
+	MemberAffiliationTypeID = 10
+	PublicAffiliationTypeID = 20
+
+	// Price is the green fee charged per member and PublicPrice is what
+	// everyone else pays.
+	Price       = 50.0
+	PublicPrice = 80.0
+
+	sessionCookie = "_chronogolf_session"
+)
+
+// Server is an in memory Chronogolf. Tee times fill up as they're reserved,
+// mutating requests need the CSRF token from the widget page and sessions can
+// be expired to force a new login.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	config       golfer.AppConfig
+	accounts     []*account
+	sessions     map[string]*account
+	clubs        []golfer.Club
+	courses      []golfer.Course
+	affTypes     []golfer.AffiliationType
+	teetimes     []*golfer.TeeTime
+	reservations []*golfer.Reservation
+	nextID       int
+	onReserve    func(teetimeID int)
+}
+
+// account is a Chronogolf user and their password.
+type account struct {
+	password string
+	user     golfer.SessionResponse
+}
+
+func newAccount(id int, email, password string) *account {
+	return &account{
+		password: password,
+		user: golfer.SessionResponse{
+			ID:        id,
+			Email:     email,
+			FirstName: "Test",
+			LastName:  "Golfer",
+			Affiliations: []golfer.Affiliation{
+				{
+					ID:                id,
+					Role:              "member",
+					OrganizationID:    ClubID,
+					OrganizationType:  "Club",
+					AffiliationTypeID: MemberAffiliationTypeID,
+				},
+			},
+		},
+	}
+}
+
+// NewServer starts a fake with a single 18 hole course at ClubID, member and
+// public affiliation types and a member of that club, User.
+func NewServer() *Server {
+	s := &Server{
+		config: golfer.AppConfig{
+			CSRFToken: randomToken(),
+			ClubID:    ClubID,
+		},
+		accounts: []*account{newAccount(UserID, User, Password)},
+		sessions: map[string]*account{},
+		clubs: []golfer.Club{
+			{ID: ClubID, Name: "Test Golf Club", Slug: "test-golf-club", Currency: "CAD"},
+		},
+		courses: []golfer.Course{
+			{
+				ID:                   CourseID,
+				Name:                 "Championship",
+				Holes:                18,
+				Par:                  72,
+				ClubID:               ClubID,
+				OnlineBookingEnabled: true,
+			},
+		},
+		affTypes: []golfer.AffiliationType{
+			{ID: MemberAffiliationTypeID, ClubID: ClubID, Name: "Member"},
+			{ID: PublicAffiliationTypeID, ClubID: ClubID, Name: "Public", Public: true},
+		},
+		nextID: 1000,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/en/club/", s.handleWidget)
+	mux.HandleFunc("/private_api/sessions", s.handleSessions)
+	mux.HandleFunc("/private_api/clubs/", s.handleClubs)
+	mux.HandleFunc("/private_api/teetimes", s.handleTeeTimes)
+	mux.HandleFunc("/private_api/reservations/options", s.handleOptions)
+	mux.HandleFunc("/private_api/reservations", s.handleCreateReservation)
+	mux.HandleFunc("/private_api/reservations/", s.handleCancelReservation)
+	mux.HandleFunc("/private_api/users/", s.handleUserReservations)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+// AddTeeTime adds a tee time to the course with the given date (DayFormat),
+// start time and number of free slots.
+func (s *Server) AddTeeTime(courseID int, date, start string, freeSlots int) golfer.TeeTime {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tt := &golfer.TeeTime{
+		ID:        s.id(),
+		CourseID:  courseID,
+		Date:      date,
+		StartTime: start,
+		Hole:      1,
+		Round:     1,
+		Active:    true,
+		Format:    "normal",
+		FreeSlots: freeSlots,
+	}
+	s.teetimes = append(s.teetimes, tt)
+	return *tt
+}
+
+// AddClub adds a club the user isn't affiliated with.
+func (s *Server) AddClub(c golfer.Club) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clubs = append(s.clubs, c)
+}
+
+// AddUser adds another member of ClubID and returns their user ID.
+func (s *Server) AddUser(email, password string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := newAccount(s.id(), email, password)
+	s.accounts = append(s.accounts, a)
+	return a.user.ID
+}
+
+// AddAffiliation makes every user a member of another club.
+func (s *Server) AddAffiliation(a golfer.Affiliation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, acc := range s.accounts {
+		acc.user.Affiliations = append(acc.user.Affiliations, a)
+	}
+}
+
+// AddCourse adds a course to the club with c.ClubID.
+func (s *Server) AddCourse(c golfer.Course) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.courses = append(s.courses, c)
+}
+
+// AddAffiliationType adds an affiliation type to the club with t.ClubID.
+// Players of public types pay PublicPrice, everyone else pays Price.
+func (s *Server) AddAffiliationType(t golfer.AffiliationType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.affTypes = append(s.affTypes, t)
+}
+
+// TakeSlots books n slots of a tee time as someone else.
+func (s *Server) TakeSlots(teetimeID, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tt := s.teetime(teetimeID); tt != nil {
+		tt.FreeSlots -= n
+		if tt.FreeSlots < 0 {
+			tt.FreeSlots = 0
+		}
+	}
+}
+
+// ReleaseSlots frees n slots of a tee time, as if someone cancelled.
+func (s *Server) ReleaseSlots(teetimeID, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tt := s.teetime(teetimeID); tt != nil {
+		tt.FreeSlots += n
+	}
+}
+
+// OnReserve calls f with the tee time ID before each reservation is created,
+// e.g. to have someone else grab the slot first with TakeSlots. f is called
+// without the server lock held.
+func (s *Server) OnReserve(f func(teetimeID int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onReserve = f
+}
+
+// ExpireSessions logs everyone out.
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = map[string]*account{}
+}
+
+// RotateCSRFToken invalidates the CSRF token handed out by the widget page.
+func (s *Server) RotateCSRFToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config.CSRFToken = randomToken()
+}
+
+// Reservations returns every reservation made, including canceled ones.
+func (s *Server) Reservations() []golfer.Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []golfer.Reservation
+	for _, r := range s.reservations {
+		out = append(out, *r)
+	}
+	return out
+}
+
+func (s *Server) course(id int) golfer.Course {
+	for _, c := range s.courses {
+		if c.ID == id {
+			return c
+		}
+	}
+	return golfer.Course{}
+}
+
+func (s *Server) teetime(id int) *golfer.TeeTime {
+	for _, tt := range s.teetimes {
+		if tt.ID == id {
+			return tt
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string][]string{"errors": {msg}})
+}
+
+// authorized checks the session cookie and, for mutating requests, the CSRF
+// token. It writes an error and returns false if either is missing.
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request, needSession bool) bool {
+	if r.Method != http.MethodGet && r.Header.Get("X-CSRF-Token") != s.config.CSRFToken {
+		writeError(w, http.StatusForbidden, "invalid authenticity token")
+		return false
+	}
+	if !needSession {
+		return true
+	}
+	if s.session(r) == nil {
+		writeError(w, http.StatusUnauthorized, "you need to sign in")
+		return false
+	}
+	return true
+}
+
+// session returns the account logged in by r's session cookie.
+func (s *Server) session(r *http.Request) *account {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	return s.sessions[c.Value]
+}
+
+var widgetTmpl = template.Must(template.New("widget").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<script>
+  window.CHRONOGOLF_CONFIG = {{.}}
+</script>
+</head>
+<body></body>
+</html>
+`))
+
+func (s *Server) handleWidget(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	config := s.config
+	s.mu.Unlock()
+
+	var buf strings.Builder
+	if err := json.NewEncoder(&buf).Encode(config); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	widgetTmpl.Execute(w, template.JS(strings.TrimSpace(buf.String())))
+}
+
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if !s.authorized(w, r, true) {
+			return
+		}
+		writeJSON(w, http.StatusOK, s.session(r).user)
+
+	case http.MethodPost:
+		if !s.authorized(w, r, false) {
+			return
+		}
+		var req golfer.LoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var acc *account
+		for _, a := range s.accounts {
+			if a.user.Email == req.Session.Email && a.password == req.Session.Password {
+				acc = a
+			}
+		}
+		if acc == nil {
+			writeError(w, http.StatusUnauthorized, "invalid email or password")
+			return
+		}
+		token := randomToken()
+		s.sessions[token] = acc
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: token, Path: "/"})
+		writeJSON(w, http.StatusCreated, acc.user)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handleClubs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/private_api/clubs/"), "/")
+	clubID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if len(parts) == 1 {
+		for _, c := range s.clubs {
+			if c.ID == clubID {
+				writeJSON(w, http.StatusOK, c)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "club not found")
+		return
+	}
+	if len(parts) == 2 && parts[1] == "affiliation_types" {
+		types := []golfer.AffiliationType{}
+		for _, t := range s.affTypes {
+			if t.ClubID == clubID {
+				types = append(types, t)
+			}
+		}
+		writeJSON(w, http.StatusOK, types)
+		return
+	}
+	if len(parts) != 2 || parts[1] != "courses" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	courses := []golfer.Course{}
+	for _, c := range s.courses {
+		if c.ClubID == clubID {
+			courses = append(courses, c)
+		}
+	}
+	writeJSON(w, http.StatusOK, courses)
+}
+
+func (s *Server) handleTeeTimes(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := r.URL.Query()
+	courseID, err := strconv.Atoi(q.Get("course_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid course_id")
+		return
+	}
+	date := q.Get("date")
+	if len(date) != len(golfer.DayFormat) {
+		writeError(w, http.StatusBadRequest, "invalid date")
+		return
+	}
+	if q.Get("affiliation_type_ids") == "" {
+		writeError(w, http.StatusBadRequest, "missing affiliation_type_ids")
+		return
+	}
+
+	tts := []golfer.TeeTime{}
+	for _, tt := range s.teetimes {
+		if tt.CourseID == courseID && tt.Date == date {
+			tts = append(tts, *tt)
+		}
+	}
+	writeJSON(w, http.StatusOK, tts)
+}
+
+func (s *Server) affiliationType(id int) (golfer.AffiliationType, bool) {
+	for _, t := range s.affTypes {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return golfer.AffiliationType{}, false
+}
+
+func (s *Server) roundLines(t golfer.AffiliationType, holes int) []golfer.RoundLine {
+	price := Price
+	if t.Public {
+		price = PublicPrice
+	}
+	price *= float64(holes) / 18
+	return []golfer.RoundLine{
+		{
+			ProductID:         1,
+			ProductRuleID:     1,
+			OriginalUnitPrice: price,
+			UnitPrice:         price,
+			UnitQuantity:      1,
+			AmountSubtotal:    price,
+			AmountTotal:       price,
+		},
+	}
+}
+
+func (s *Server) handleOptions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorized(w, r, true) {
+		return
+	}
+	q := r.URL.Query()
+	teetimeID, err := strconv.Atoi(q.Get("teetime_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid teetime_id")
+		return
+	}
+	holes, err := strconv.Atoi(q.Get("nb_holes"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid nb_holes")
+		return
+	}
+	var types []golfer.AffiliationType
+	for _, v := range strings.Split(q.Get("affiliation_type_ids"), ",") {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid affiliation_type_ids")
+			return
+		}
+		t, ok := s.affiliationType(id)
+		if !ok {
+			writeError(w, http.StatusUnprocessableEntity, "unknown affiliation type")
+			return
+		}
+		types = append(types, t)
+	}
+
+	opts := []golfer.Reservation{}
+	tt := s.teetime(teetimeID)
+	if tt != nil {
+		if err := s.course(tt.CourseID).ValidateHoles(holes); err != nil || holes == 0 {
+			writeError(w, http.StatusBadRequest, "invalid nb_holes")
+			return
+		}
+	}
+	if tt != nil && !tt.Blocked && tt.FreeSlots >= len(types) {
+		opt := golfer.Reservation{
+			ClubID:    s.course(tt.CourseID).ClubID,
+			TeetimeID: tt.ID,
+			Holes:     holes,
+		}
+		for _, t := range types {
+			opt.Rounds = append(opt.Rounds, golfer.Round{
+				AffiliationTypeID: t.ID,
+				RoundLines:        s.roundLines(t, holes),
+			})
+		}
+		opts = append(opts, opt)
+	}
+	writeJSON(w, http.StatusOK, opts)
+}
+
+func (s *Server) handleCreateReservation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	onReserve := s.onReserve
+	s.mu.Unlock()
+
+	var req golfer.ReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if onReserve != nil {
+		onReserve(req.Reservation.TeetimeID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorized(w, r, true) {
+		return
+	}
+	tt := s.teetime(req.Reservation.TeetimeID)
+	if tt == nil {
+		writeError(w, http.StatusNotFound, "teetime not found")
+		return
+	}
+	if err := s.course(tt.CourseID).ValidateHoles(req.Reservation.Holes); err != nil || req.Reservation.Holes == 0 {
+		writeError(w, http.StatusBadRequest, "invalid holes")
+		return
+	}
+	players := len(req.Reservation.RoundsAttributes)
+	if players == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "rounds can't be blank")
+		return
+	}
+	if tt.Blocked || tt.FreeSlots < players {
+		writeError(w, http.StatusUnprocessableEntity, "teetime is no longer available")
+		return
+	}
+	tt.FreeSlots -= players
+
+	res := req.Reservation
+	res.ID = s.id()
+	res.State = "confirmed"
+	res.CreatedUserID = s.session(r).user.ID
+	res.Teetime.ID = tt.ID
+	res.Teetime.CourseID = tt.CourseID
+	res.Teetime.Date = tt.Date
+	res.Teetime.StartTime = tt.StartTime
+	res.Teetime.FreeSlots = tt.FreeSlots
+	res.Teetime.Active = tt.Active
+	res.Rounds = nil
+	for _, round := range res.RoundsAttributes {
+		round.ID = s.id()
+		round.ReservationID = res.ID
+		round.ClubID = res.ClubID
+		round.RoundLines = round.RoundLinesAttributes
+		round.RoundLinesAttributes = nil
+		res.Rounds = append(res.Rounds, round)
+	}
+	res.RoundsAttributes = nil
+	s.reservations = append(s.reservations, &res)
+	writeJSON(w, http.StatusCreated, res)
+}
+
+func (s *Server) handleCancelReservation(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var id int
+	if _, err := fmt.Sscanf(r.URL.Path, "/private_api/reservations/%d/cancel", &id); err != nil || r.Method != http.MethodPut {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !s.authorized(w, r, true) {
+		return
+	}
+	for _, res := range s.reservations {
+		if res.ID != id {
+			continue
+		}
+		if res.State == "canceled" {
+			writeError(w, http.StatusUnprocessableEntity, "reservation is already canceled")
+			return
+		}
+		res.State = "canceled"
+		if tt := s.teetime(res.TeetimeID); tt != nil {
+			tt.FreeSlots += len(res.Rounds)
+		}
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	writeError(w, http.StatusNotFound, "reservation not found")
+}
+
+func (s *Server) handleUserReservations(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var id int
+	if _, err := fmt.Sscanf(r.URL.Path, "/private_api/users/%d/reservations", &id); err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !s.authorized(w, r, true) {
+		return
+	}
+	out := []golfer.Reservation{}
+	for _, res := range s.reservations {
+		if res.CreatedUserID == id && res.State != "canceled" {
+			out = append(out, *res)
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -2,6 +2,7 @@ package golfer
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -60,21 +61,81 @@ type Round struct {
 	EventTicketID     interface{} `json:"event_ticket_id"`
 	State             string      `json:"state"`
 	UserID            int         `json:"user_id,omitempty"`
-	Customer          struct {
-		ID        int         `json:"id,omitempty"`
-		ClubID    int         `json:"club_id,omitempty"`
-		FirstName string      `json:"first_name,omitempty"`
-		LastName  string      `json:"last_name,omitempty"`
-		Phone     string      `json:"phone,omitempty"`
-		Email     string      `json:"email,omitempty"`
-		MemberNo  string      `json:"member_no,omitempty"`
-		BagNumber interface{} `json:"bag_number,omitempty"`
-	} `json:"customer,omitempty"`
+	Customer          Customer    `json:"customer,omitempty"`
 
 	RoundLines           []RoundLine `json:"round_lines,omitempty"`
 	RoundLinesAttributes []RoundLine `json:"round_lines_attributes,omitempty"`
 }
 
+type Customer struct {
+	ID        int         `json:"id,omitempty"`
+	ClubID    int         `json:"club_id,omitempty"`
+	FirstName string      `json:"first_name,omitempty"`
+	LastName  string      `json:"last_name,omitempty"`
+	Phone     string      `json:"phone,omitempty"`
+	Email     string      `json:"email,omitempty"`
+	MemberNo  string      `json:"member_no,omitempty"`
+	BagNumber interface{} `json:"bag_number,omitempty"`
+}
+
+// Player is someone booked into a reservation alongside the logged in user.
+// Players are either linked Chronogolf users, club members identified by
+// their member number, named guests or, with no details at all, anonymous
+// guests.
+type Player struct {
+	// UserID is the player's Chronogolf user, if they have one.
+	UserID    int    `json:",omitempty"`
+	FirstName string `json:",omitempty"`
+	LastName  string `json:",omitempty"`
+	Email     string `json:",omitempty"`
+	Phone     string `json:",omitempty"`
+	MemberNo  string `json:",omitempty"`
+	// AffiliationTypeID is how the player's round is priced. Zero uses the
+	// logged in user's.
+	AffiliationTypeID int `json:",omitempty"`
+}
+
+// Name returns the player's full name.
+func (p Player) Name() string {
+	return strings.TrimSpace(p.FirstName + " " + p.LastName)
+}
+
+func (p Player) anonymous() bool {
+	return p.UserID == 0 && p.FirstName == "" && p.LastName == "" &&
+		p.Email == "" && p.Phone == "" && p.MemberNo == ""
+}
+
+// Validate checks that the player can be identified to the club.
+func (p Player) Validate() error {
+	if p.anonymous() {
+		return nil
+	}
+	if p.UserID == 0 && (p.FirstName == "" || p.LastName == "") {
+		return errors.New("players need a Chronogolf user or a first and last name")
+	}
+	return nil
+}
+
+func (p Player) round(af Affiliation, rla []RoundLine) Round {
+	r := Round{
+		AffiliationTypeID:    af.AffiliationTypeID,
+		State:                "reserved",
+		UserID:               p.UserID,
+		RoundLinesAttributes: rla,
+		Customer: Customer{
+			FirstName: p.FirstName,
+			LastName:  p.LastName,
+			Email:     p.Email,
+			Phone:     p.Phone,
+			MemberNo:  p.MemberNo,
+		},
+	}
+	if p.AffiliationTypeID != 0 {
+		r.AffiliationTypeID = p.AffiliationTypeID
+	}
+	return r
+}
+
 type RoundLine struct {
 	ID                    interface{} `json:"id"`
 	RoundID               interface{} `json:"round_id"`
@@ -95,7 +156,8 @@ func (g *Golfer) Reservations() ([]Reservation, error) {
 	if err := g.ensureLoggedIn(); err != nil {
 		return nil, err
 	}
-	url := fmt.Sprintf(reservationUpcomingAPI, g.userSession.ID, g.userSession.ID)
+	id := g.currentSession().ID
+	url := fmt.Sprintf(reservationUpcomingAPI, id, id)
 	var r []Reservation
 	if err := g.getJSON(url, &r); err != nil {
 		return nil, err
@@ -103,48 +165,99 @@ func (g *Golfer) Reservations() ([]Reservation, error) {
 	return r, nil
 }
 
-func (g *Golfer) ReservationOptions(af Affiliation, c Course, tt TeeTime, players int) (Reservation, error) {
-	url := fmt.Sprintf(reservationOptionsAPI, affiliationTypeIDs(af, players), tt.ID, c.Holes)
+// Total returns the total price of every round in the reservation.
+func (r Reservation) Total() float64 {
+	var total float64
+	for _, round := range r.Rounds {
+		total += round.Total()
+	}
+	return total
+}
+
+// Total returns the price of the round.
+func (r Round) Total() float64 {
+	var total float64
+	for _, rl := range r.RoundLines {
+		if rl.AmountTotal != 0 {
+			total += rl.AmountTotal
+		} else {
+			total += rl.UnitPrice * float64(rl.UnitQuantity)
+		}
+	}
+	return total
+}
+
+// holesOrDefault validates holes against the course, returning the course's
+// default if it's zero.
+func holesOrDefault(c Course, holes int) (int, error) {
+	if err := c.ValidateHoles(holes); err != nil {
+		return 0, err
+	}
+	if holes == 0 {
+		return c.Holes, nil
+	}
+	return holes, nil
+}
+
+// ReservationOptions returns the priced rounds for booking tt with a player
+// of each of the affiliation types, in the same order. holes may be zero to
+// use the course's default.
+func (g *Golfer) ReservationOptions(c Course, tt TeeTime, affiliationTypeIDs []int, holes int) (Reservation, error) {
+	holes, err := holesOrDefault(c, holes)
+	if err != nil {
+		return Reservation{}, err
+	}
+	url := fmt.Sprintf(reservationOptionsAPI, joinIDs(affiliationTypeIDs), tt.ID, holes)
 	var opts []Reservation
 	if err := g.getJSON(url, &opts); err != nil {
 		return Reservation{}, err
 	}
 	if len(opts) == 0 {
-		return Reservation{}, errors.New("no options")
+		return Reservation{}, ErrNoOptions
 	}
 	return opts[0], nil
 }
 
-func (g *Golfer) Reserve(af Affiliation, c Course, tt TeeTime, players int) (Reservation, error) {
+// Reserve books tt for the user and players-1 others and returns the created
+// reservation. The others are filled from guests first and then booked as
+// anonymous guests. Each player is priced with their affiliation type, see
+// AffiliationTypeIDs. holes may be zero to use the course's default.
+func (g *Golfer) Reserve(af Affiliation, c Course, tt TeeTime, players, holes int, guests ...Player) (Reservation, error) {
 	if err := g.ensureLoggedIn(); err != nil {
 		return Reservation{}, err
 	}
+	if len(guests) > players-1 {
+		return Reservation{}, errors.Errorf("%d guests don't fit in %d players", len(guests), players)
+	}
+	for _, p := range guests {
+		if err := p.Validate(); err != nil {
+			return Reservation{}, err
+		}
+	}
 
-	opts, err := g.ReservationOptions(af, c, tt, players)
+	holes, err := holesOrDefault(c, holes)
+	if err != nil {
+		return Reservation{}, err
+	}
+	opts, err := g.ReservationOptions(c, tt, AffiliationTypeIDs(af, players, guests...), holes)
 	if err != nil {
 		return Reservation{}, err
 	}
 
-	if len(opts.Rounds) == 0 {
-		return Reservation{}, errors.New("no rounds present in round options")
+	if len(opts.Rounds) != players {
+		return Reservation{}, errors.Errorf("got %d rounds in round options for %d players", len(opts.Rounds), players)
 	}
-	rla := opts.Rounds[0].RoundLines
 
 	primary := Round{
 		AffiliationTypeID:    af.AffiliationTypeID,
 		State:                "reserved",
-		UserID:               g.userSession.ID,
-		RoundLinesAttributes: rla,
-	}
-	secondary := Round{
-		AffiliationTypeID:    af.AffiliationTypeID,
-		State:                "reserved",
-		RoundLinesAttributes: rla,
+		UserID:               g.currentSession().ID,
+		RoundLinesAttributes: opts.Rounds[0].RoundLines,
 	}
 	res := Reservation{
 		AgreedOnTerms: true,
 		ClubID:        af.OrganizationID,
-		Holes:         c.Holes,
+		Holes:         holes,
 		MadeOnline:    true,
 		Source:        "chronogolf",
 		State:         "confirmed",
@@ -153,18 +266,36 @@ func (g *Golfer) Reserve(af Affiliation, c Course, tt TeeTime, players int) (Res
 			primary,
 		},
 	}
-	for i := 0; i < players-1; i++ {
-		res.RoundsAttributes = append(res.RoundsAttributes, secondary)
+	for i := 1; i < players; i++ {
+		var p Player
+		if i-1 < len(guests) {
+			p = guests[i-1]
+		}
+		res.RoundsAttributes = append(res.RoundsAttributes, p.round(af, opts.Rounds[i].RoundLines))
 	}
 
 	req := ReservationRequest{
 		Reservation: res,
 	}
 
-	// TODO: resp
-	var resp struct{}
+	var resp Reservation
 	if err := g.postJSON(reservationAPI, req, &resp); err != nil {
 		return Reservation{}, err
 	}
-	return Reservation{}, nil
+	return resp, nil
+}
+
+// CancelReservation cancels one of the user's upcoming reservations and
+// returns it in its canceled state.
+func (g *Golfer) CancelReservation(id int) (Reservation, error) {
+	if err := g.ensureLoggedIn(); err != nil {
+		return Reservation{}, err
+	}
+
+	url := fmt.Sprintf(reservationCancelAPI, id)
+	var resp Reservation
+	if err := g.putJSON(url, struct{}{}, &resp); err != nil {
+		return Reservation{}, err
+	}
+	return resp, nil
 }
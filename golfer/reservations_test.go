@@ -0,0 +1,269 @@
+package golfer_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+func newTestGolfer(t *testing.T) (*golfer.Golfer, *golfertest.Server) {
+	fake := golfertest.NewServer()
+	t.Cleanup(fake.Close)
+	g, err := golfer.New(golfertest.User, golfer.Password(golfertest.Password), golfer.WithBaseURL(fake.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, fake
+}
+
+func TestReserve(t *testing.T) {
+	g, fake := newTestGolfer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 2)
+
+	af, err := g.Affiliation(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := g.Course(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tts, err := g.TeeTimes(c, "2018-05-17", golfer.AffiliationTypeIDs(af, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tts) != 1 {
+		t.Fatalf("TeeTimes = %+v", tts)
+	}
+
+	res, err := g.Reserve(af, c, tts[0], 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID == 0 || res.Teetime.StartTime != "07:10" || len(res.Rounds) != 2 || res.Total() != 2*golfertest.Price {
+		t.Errorf("Reserve = %+v", res)
+	}
+	reservations, err := g.Reservations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reservations) != 1 || len(reservations[0].Rounds) != 2 || reservations[0].ID != res.ID {
+		t.Errorf("Reservations = %+v", reservations)
+	}
+
+	// The slot is now full.
+	if _, err := g.Reserve(af, c, tts[0], 1, 0); golfer.Classify(err) != golfer.ErrSlotTaken {
+		t.Errorf("Reserve on a full tee time = %v; not slot taken", err)
+	}
+}
+
+func TestExpiredSession(t *testing.T) {
+	g, fake := newTestGolfer(t)
+
+	fake.ExpireSessions()
+	fake.RotateCSRFToken()
+	_, err := g.Reservations()
+	if golfer.Classify(err) != golfer.ErrAuth {
+		t.Fatalf("Reservations with expired session = %v; not auth", err)
+	}
+	if err := g.Relogin(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Reservations(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelReservation(t *testing.T) {
+	g, fake := newTestGolfer(t)
+	tt := fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+
+	af, err := g.Affiliation(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := g.Course(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Reserve(af, c, tt, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	id := fake.Reservations()[0].ID
+
+	out, err := g.CancelReservation(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != id || out.State != "canceled" {
+		t.Errorf("CancelReservation(%d) = %+v", id, out)
+	}
+	reservations, err := g.Reservations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reservations) != 0 {
+		t.Errorf("Reservations after cancel = %+v", reservations)
+	}
+
+	_, err = g.CancelReservation(id)
+	if se, ok := err.(*golfer.StatusError); !ok || se.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("CancelReservation(%d) again = %v; not 422", id, err)
+	}
+	_, err = g.CancelReservation(id + 1000)
+	if se, ok := err.(*golfer.StatusError); !ok || se.StatusCode != http.StatusNotFound {
+		t.Errorf("CancelReservation(%d) = %v; not 404", id+1000, err)
+	}
+
+	// The slots are free again.
+	tts, err := g.TeeTimes(c, "2018-05-17", golfer.AffiliationTypeIDs(af, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tts[0].FreeSlots != 4 {
+		t.Errorf("FreeSlots after cancel = %d; not 4", tts[0].FreeSlots)
+	}
+}
+
+func TestReserveHoles(t *testing.T) {
+	g, fake := newTestGolfer(t)
+	tt := fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+
+	af, err := g.Affiliation(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := g.Course(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := g.ReservationOptions(c, tt, golfer.AffiliationTypeIDs(af, 2), 36); err == nil {
+		t.Errorf("ReservationOptions with 36 holes on %+v succeeded", c)
+	}
+	opts, err := g.ReservationOptions(c, tt, golfer.AffiliationTypeIDs(af, 2), 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := golfertest.Price; opts.Total() != want {
+		t.Errorf("9 hole Total() = %v; not %v", opts.Total(), want)
+	}
+
+	if _, err := g.Reserve(af, c, tt, 2, 9); err != nil {
+		t.Fatal(err)
+	}
+	if holes := fake.Reservations()[0].Holes; holes != 9 {
+		t.Errorf("reserved %d holes; not 9", holes)
+	}
+}
+
+func TestReserveGuests(t *testing.T) {
+	g, fake := newTestGolfer(t)
+	tt := fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+
+	af, err := g.Affiliation(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := g.Course(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guests := []golfer.Player{
+		{UserID: 42},
+		{FirstName: "Jane", LastName: "Doe", MemberNo: "1234"},
+	}
+	if _, err := g.Reserve(af, c, tt, 2, 0, guests...); err == nil {
+		t.Errorf("Reserve with too many guests succeeded")
+	}
+	if _, err := g.Reserve(af, c, tt, 3, 0, golfer.Player{FirstName: "Jane"}); err == nil {
+		t.Errorf("Reserve with an unnamed guest succeeded")
+	}
+	if _, err := g.Reserve(af, c, tt, 4, 0, guests...); err != nil {
+		t.Fatal(err)
+	}
+
+	rounds := fake.Reservations()[0].Rounds
+	if len(rounds) != 4 {
+		t.Fatalf("rounds = %+v", rounds)
+	}
+	if rounds[0].UserID != golfertest.UserID || rounds[1].UserID != 42 {
+		t.Errorf("linked rounds = %+v, %+v", rounds[0], rounds[1])
+	}
+	if c := rounds[2].Customer; c.FirstName != "Jane" || c.MemberNo != "1234" {
+		t.Errorf("member round customer = %+v", c)
+	}
+	if rounds[3].UserID != 0 || rounds[3].Customer.FirstName != "" {
+		t.Errorf("anonymous round = %+v", rounds[3])
+	}
+}
+
+func TestReserveAffiliationTypes(t *testing.T) {
+	g, fake := newTestGolfer(t)
+	tt := fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+
+	types, err := g.AffiliationTypes(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var public []int
+	for _, at := range types {
+		if at.Public {
+			public = append(public, at.ID)
+		}
+	}
+	if len(public) != 1 || public[0] != golfertest.PublicAffiliationTypeID {
+		t.Fatalf("AffiliationTypes = %+v", types)
+	}
+
+	af, err := g.Affiliation(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := g.Course(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guests := []golfer.Player{
+		{FirstName: "Jane", LastName: "Doe", AffiliationTypeID: golfertest.PublicAffiliationTypeID},
+		{AffiliationTypeID: golfertest.PublicAffiliationTypeID},
+	}
+	ids := golfer.AffiliationTypeIDs(af, 4, guests...)
+	want := []int{
+		golfertest.MemberAffiliationTypeID,
+		golfertest.PublicAffiliationTypeID,
+		golfertest.PublicAffiliationTypeID,
+		golfertest.MemberAffiliationTypeID,
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("AffiliationTypeIDs = %v; not %v", ids, want)
+	}
+	opts, err := g.ReservationOptions(c, tt, ids, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2*golfertest.Price + 2*golfertest.PublicPrice; opts.Total() != want {
+		t.Errorf("Total() = %v; not %v", opts.Total(), want)
+	}
+
+	if _, err := g.Reserve(af, c, tt, 4, 0, guests...); err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	var total float64
+	for _, r := range fake.Reservations()[0].Rounds {
+		got = append(got, r.AffiliationTypeID)
+		total += r.Total()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round affiliation types = %v; not %v", got, want)
+	}
+	if total != opts.Total() {
+		t.Errorf("reserved total = %v; not %v", total, opts.Total())
+	}
+}
@@ -25,19 +25,44 @@ type TeeTime struct {
 	Departure  interface{} `json:"departure"`
 }
 
-const DateFormat = "2006-01-02T15:04"
+const (
+	DateFormat = "2006-01-02T15:04"
+	// DayFormat is the format of TeeTime.Date and the date passed to
+	// TeeTimes.
+	DayFormat = "2006-01-02"
+)
 
 func (t TeeTime) Time() (time.Time, error) {
 	return time.ParseInLocation(DateFormat, fmt.Sprintf("%sT%s", t.Date, t.StartTime), time.Local)
 }
 
-func affiliationTypeIDs(af Affiliation, players int) string {
-	affiliationTypeID := strconv.Itoa(af.AffiliationTypeID)
-	return strings.Join(repeatString(affiliationTypeID, players), ",")
+// AffiliationTypeIDs returns how each player in a reservation is priced:
+// the user with their affiliation, then guests with their own affiliation
+// type, or the user's if they don't have one, up to players in total.
+func AffiliationTypeIDs(af Affiliation, players int, guests ...Player) []int {
+	ids := []int{af.AffiliationTypeID}
+	for i := 1; i < players; i++ {
+		id := af.AffiliationTypeID
+		if i-1 < len(guests) && guests[i-1].AffiliationTypeID != 0 {
+			id = guests[i-1].AffiliationTypeID
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func joinIDs(ids []int) string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.Itoa(id)
+	}
+	return strings.Join(strs, ",")
 }
 
-func (g *Golfer) TeeTimes(af Affiliation, c Course, date string, players int) ([]TeeTime, error) {
-	url := fmt.Sprintf(teetimeAPI, affiliationTypeIDs(af, players), date, c.ID)
+// TeeTimes returns the tee times on a course for date (DayFormat) that have
+// room for a player of each of the affiliation types.
+func (g *Golfer) TeeTimes(c Course, date string, affiliationTypeIDs []int) ([]TeeTime, error) {
+	url := fmt.Sprintf(teetimeAPI, joinIDs(affiliationTypeIDs), date, c.ID)
 
 	var tt []TeeTime
 	if err := g.getJSON(url, &tt); err != nil {
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/pkg/errors"
+)
+
+var (
+	historyFile = flag.String("history", "", "the file to append the history of booking runs to, defaults to -file with a .history.jsonl extension")
+)
+
+// maxHistoryLine is the longest history entry that can be read back.
+const maxHistoryLine = 1 << 20
+
+// maxHistory is how many bookings are kept in each user's History in the data
+// file. The history file has the rest.
+const maxHistory = 20
+
+// Run is the record of one try at booking a pending reservation. Every run is
+// appended to the history file as a line of JSON.
+type Run struct {
+	Time time.Time
+	// User is the name of the user the reservation was booked for.
+	User    string
+	Pending PendingReservation
+	// Candidates are the tee times that were considered, best first.
+	Candidates []golfer.TeeTime `json:",omitempty"`
+	Attempts   []Attempt        `json:",omitempty"`
+	Outcome    Outcome
+	Error      string `json:",omitempty"`
+	// Total is the price of the booked reservation.
+	Total float64 `json:",omitempty"`
+}
+
+// Booked returns the reservation the run made, if any.
+func (r Run) Booked() *golfer.Reservation {
+	if len(r.Attempts) == 0 {
+		return nil
+	}
+	return r.Attempts[len(r.Attempts)-1].Reservation
+}
+
+// consider adds the tee times of cs to the run's candidates, skipping any it
+// already has.
+func (r *Run) consider(cs []candidate) {
+	seen := map[int]bool{}
+	for _, tt := range r.Candidates {
+		seen[tt.ID] = true
+	}
+	for _, c := range cs {
+		if !seen[c.tt.ID] {
+			seen[c.tt.ID] = true
+			r.Candidates = append(r.Candidates, c.tt)
+		}
+	}
+}
+
+func historyPath() string {
+	if *historyFile != "" {
+		return *historyFile
+	}
+	return strings.TrimSuffix(*saveFile, filepath.Ext(*saveFile)) + ".history.jsonl"
+}
+
+// record appends the outcome of trying to book p for u to the history file.
+// Errors are logged since a booking shouldn't fail because its history
+// couldn't be written.
+func (s *server) record(u *User, p PendingReservation, run Run, err error) {
+	run.Time = now()
+	run.User = u.Name
+	run.Pending = p
+	run.Outcome = outcomeOf(err)
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if res := run.Booked(); res != nil {
+		run.Total = res.Total()
+	}
+	if err := appendHistory(run); err != nil {
+		log.Printf("failed to write history: %+v", err)
+	}
+}
+
+func appendHistory(run Run) error {
+	b, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, dataFileMode)
+	if err != nil {
+		return err
+	}
+	// OpenFile keeps the permissions of an existing file.
+	if err := f.Chmod(dataFileMode); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readHistory returns user's runs from the history file between from and to,
+// newest first. Zero times leave that end of the range open. Runs from before
+// flog had users don't name one and are only returned with legacy. Lines that
+// can't be parsed, like one torn by a crash mid-write, are logged and skipped.
+func readHistory(user string, legacy bool, from, to time.Time) ([]Run, error) {
+	f, err := os.Open(historyPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []Run
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxHistoryLine)
+	for line := 1; scanner.Scan(); line++ {
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			log.Printf("skipping history entry %s:%d: %v", historyPath(), line, err)
+			continue
+		}
+		if run.User == "" && !legacy || run.User != "" && !strings.EqualFold(run.User, user) {
+			continue
+		}
+		if !from.IsZero() && run.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !run.Time.Before(to) {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	return runs, nil
+}
+
+// parseDayRange parses the from and to days of the history filter. to
+// includes the whole day.
+func parseDayRange(fromValue, toValue string) (from, to time.Time, err error) {
+	if fromValue != "" {
+		if from, err = time.ParseInLocation(golfer.DayFormat, fromValue, time.Local); err != nil {
+			return time.Time{}, time.Time{}, errors.Wrap(err, "invalid from value")
+		}
+	}
+	if toValue != "" {
+		if to, err = time.ParseInLocation(golfer.DayFormat, toValue, time.Local); err != nil {
+			return time.Time{}, time.Time{}, errors.Wrap(err, "invalid to value")
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must not be after to")
+	}
+	return from, to, nil
+}
+
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "must use get", 400)
+		return
+	}
+	from, to, err := parseDayRange(r.FormValue("from"), r.FormValue("to"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs, err := readHistory(u.Name, s.ownsLegacyHistory(u), from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read history: %+v", err), 500)
+		return
+	}
+	clubNames := map[int]string{}
+	for _, c := range s.clubs {
+		clubNames[c.ID] = c.Name
+	}
+	var total float64
+	for _, run := range runs {
+		total += run.Total
+	}
+	renderMarkdown(w, "history.md", struct {
+		Runs      []Run
+		ClubNames map[int]string
+		From, To  string
+		Total     float64
+	}{
+		Runs:      runs,
+		ClubNames: clubNames,
+		From:      r.FormValue("from"),
+		To:        r.FormValue("to"),
+		Total:     total,
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+func TestHistory(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 0)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:20", 4)
+
+	booked := &PendingReservation{ID: "booked", ClubID: golfertest.ClubID, Day: "2018-05-17T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateWaiting}
+	full := &PendingReservation{ID: "full", ClubID: golfertest.ClubID, Day: "2018-05-18T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateWaiting}
+	s.Users[0].Pending = []*PendingReservation{booked, full}
+	s.attemptBooking()
+
+	runs, err := readHistory(golfertest.User, false, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("runs = %+v", runs)
+	}
+	// Newest first.
+	if r := runs[1]; r.Pending.ID != "booked" || r.Outcome != OutcomeBooked || len(r.Candidates) != 1 || len(r.Attempts) != 1 || r.Total != 2*golfertest.Price {
+		t.Errorf("booked run = %+v", r)
+	}
+	if r := runs[0]; r.Pending.ID != "full" || r.Outcome != OutcomeFailed || r.Error != errNoTeeTimes.Error() || r.Booked() != nil {
+		t.Errorf("full run = %+v", r)
+	}
+
+	from, to, err := parseDayRange("2018-05-11", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs, err := readHistory(golfertest.User, false, from, to); err != nil || len(runs) != 0 {
+		t.Errorf("readHistory(golfertest.User, %s, %s) = %+v, %v", from, to, runs, err)
+	}
+	if _, _, err := parseDayRange("2018-05-11", "2018-05-10"); err == nil {
+		t.Errorf("parseDayRange with from after to succeeded")
+	}
+
+	h := testHandler(s)
+	for _, c := range []struct {
+		query string
+		code  int
+		want  string
+	}{
+		{"", http.StatusOK, "$100.00"},
+		{"?from=2018-05-10&to=2018-05-10", http.StatusOK, "07:20 (booked)"},
+		{"?from=2018-05-11", http.StatusOK, "Nothing has been tried"},
+		{"?from=yesterday", http.StatusBadRequest, "invalid from value"},
+	} {
+		req := httptest.NewRequest("GET", "/history"+c.query, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.code || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("GET /history%s = %d, missing %q:\n%s", c.query, w.Code, c.want, w.Body)
+		}
+	}
+}
+
+func TestHistoryUsers(t *testing.T) {
+	s, _ := newTestServer(t)
+	friend := s.addUser("friend@example.com", golfer.Password("birdie"))
+
+	// Runs from before flog had users don't name one.
+	legacy := Run{Time: now(), Pending: PendingReservation{ID: "legacy"}, Outcome: OutcomeBooked}
+	mine := Run{Time: now(), User: strings.ToUpper(golfertest.User), Pending: PendingReservation{ID: "mine"}, Outcome: OutcomeBooked}
+	theirs := Run{Time: now(), User: friend.Name, Pending: PendingReservation{ID: "theirs"}, Outcome: OutcomeBooked}
+	for _, run := range []Run{legacy, mine, theirs} {
+		if err := appendHistory(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		u    *User
+		want []string
+	}{
+		{s.Users[0], []string{"mine", "legacy"}},
+		{friend, []string{"theirs"}},
+	} {
+		runs, err := readHistory(c.u.Name, s.ownsLegacyHistory(c.u), time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, run := range runs {
+			got = append(got, run.Pending.ID)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("history of %s = %v; not %v", c.u.Name, got, c.want)
+		}
+	}
+}
+
+func TestHistoryTornLine(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:20", 4)
+	s.Users[0].Pending = []*PendingReservation{
+		{ID: "booked", ClubID: golfertest.ClubID, Day: "2018-05-17T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateWaiting},
+	}
+	s.attemptBooking()
+
+	b, err := ioutil.ReadFile(historyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(historyPath(), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(append(b[:len(b)/2], '\n')); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := readHistory(golfertest.User, false, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Pending.ID != "booked" {
+		t.Errorf("runs = %+v", runs)
+	}
+
+	req := httptest.NewRequest("GET", "/history", nil)
+	w := httptest.NewRecorder()
+	testHandler(s).ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "07:20 (booked)") {
+		t.Errorf("GET /history = %d:\n%s", w.Code, w.Body)
+	}
+}
+
+func TestHistoryFileMode(t *testing.T) {
+	newTestServer(t)
+	if err := ioutil.WriteFile(historyPath(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(historyPath(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendHistory(Run{User: golfertest.User}); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(historyPath()); err != nil || fi.Mode().Perm() != dataFileMode {
+		t.Errorf("history file mode = %v, %v", fi.Mode(), err)
+	}
+}
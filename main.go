@@ -2,15 +2,15 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,14 +19,16 @@ import (
 	"github.com/d4l3k/flog/golfer"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/gorilla/handlers"
+	"github.com/pkg/errors"
 	"github.com/robfig/cron"
 )
 
 var (
-	username = flag.String("user", "", "the username")
-	password = flag.String("pass", "", "the password")
+	username = flag.String("user", "", "the Chronogolf username of an account to add, needed to start without any accounts")
+	password = flag.String("pass", "", "the Chronogolf password of the -user account, prefer -pass-env, -pass-file or -secrets")
 	bind     = flag.String("bind", ":8080", "the address to bind to")
-	saveFile = flag.String("file", "flog.data", "the file to save pending data to")
+	saveFile = flag.String("file", "flog.data", "the file to save data to")
+	clubIDs  = flag.String("clubs", strconv.Itoa(golfer.DefaultClubID), "comma separated IDs of the Chronogolf clubs to book at")
 )
 
 var (
@@ -34,11 +36,13 @@ var (
 )
 
 const (
-	dataFormatVersion = 1
+	dataFormatVersion = 4
 	daysCanBook       = 8
 	defaultDaysAway   = daysCanBook + 1
 	defaultHour       = 7
 	defaultMinute     = 10
+	defaultWindow     = 30 * time.Minute
+	maxPlayers        = 4
 )
 
 // now is used so tests can override it.
@@ -55,11 +59,10 @@ func parseDate(day string) (time.Time, error) {
 }
 
 func dateIsBookable(day string) (bool, error) {
-	t, err := parseDate(day)
+	available, err := releaseTime(day)
 	if err != nil {
 		return false, err
 	}
-	available := truncTimeToDay(t).Add(-daysCanBook * 24 * time.Hour)
 	return !available.After(now()), nil
 }
 
@@ -67,44 +70,42 @@ func truncTimeToDay(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
 
-func furthestBookingTime() string {
+func furthestBookingTime() time.Time {
 	day := now().Add(defaultDaysAway * 24 * time.Hour)
-	t := time.Date(day.Year(), day.Month(), day.Day(), defaultHour, defaultMinute, 0, 0, day.Location())
-	return t.Format(golfer.DateFormat)
+	return time.Date(day.Year(), day.Month(), day.Day(), defaultHour, defaultMinute, 0, 0, day.Location())
 }
 
-type PendingReservation struct {
-	Day     string
-	Players int
+// dataStore returns where s is saved, defaulting to the JSON -file.
+func (s *server) dataStore() Store {
+	if s.store == nil {
+		s.store = fileStore{path: *saveFile}
+	}
+	return s.store
 }
 
 func (s *server) savePending() error {
-	f, err := os.OpenFile(*saveFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	if err := json.NewEncoder(f).Encode(s); err != nil {
-		return err
-	}
-	return nil
+	return s.dataStore().Save(&s.Data)
 }
 
 func (s *server) loadPending() error {
-	f, err := os.OpenFile(*saveFile, os.O_RDONLY, 0755)
-	if os.IsNotExist(err) {
-		log.Printf("Save file doesn't exist.")
-		return nil
-	}
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	if err := json.NewDecoder(f).Decode(&s); err != nil {
+	d, err := s.dataStore().Load()
+	if err != nil || d == nil {
 		return err
 	}
+	s.Data = *d
 	if s.DataFormatVersion != dataFormatVersion {
-		log.Fatalf("Flog data file version (%d) does not match current (%d)!", s.DataFormatVersion, dataFormatVersion)
+		return errors.Errorf("flog data file version (%d) does not match current (%d)", s.DataFormatVersion, dataFormatVersion)
+	}
+	for _, p := range s.allPending() {
+		if p.ID == "" {
+			p.ID = newID()
+		}
+		if p.ClubID == 0 {
+			p.ClubID = golfer.DefaultClubID
+		}
+		if p.State == StateAttempting {
+			p.setState(StateFailed, errors.New("interrupted while booking"))
+		}
 	}
 	return nil
 }
@@ -119,7 +120,7 @@ func renderMarkdown(w http.ResponseWriter, tmpl string, args interface{}) {
 	w.Write([]byte(`
 	<meta name="viewport" content="width=device-width, initial-scale=1">
 	<title>flog</title>
-	<link rel="stylesheet" href="static/styles.css">
+	<link rel="stylesheet" href="/static/styles.css">
 	`))
 	if _, err := w.Write(blackfriday.Run(buf.Bytes())); err != nil {
 		http.Error(w, fmt.Sprintf("%+v", err), 500)
@@ -128,29 +129,66 @@ func renderMarkdown(w http.ResponseWriter, tmpl string, args interface{}) {
 }
 
 type server struct {
-	g *golfer.Golfer
-
-	mu sync.Mutex
-
-	DataFormatVersion int
-	Pending           []PendingReservation
+	clubs   []golfer.Club
+	courses map[int][]golfer.Course
+	// guestTypes are the public affiliation types at each club guests can
+	// be booked with.
+	guestTypes map[int][]golfer.AffiliationType
+	// memberTypes are the other affiliation types at each club, which only
+	// members can be booked with.
+	memberTypes map[int][]golfer.AffiliationType
+
+	mu    sync.Mutex
+	wake  chan struct{}
+	store Store
+	// background tracks bookings started by requests.
+	background sync.WaitGroup
+
+	Data
 }
 
 func newServer() error {
 	log.Println("Running...")
 
+	store, err := openStore(*storeKind, *saveFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
 	s := server{
-		DataFormatVersion: dataFormatVersion,
+		Data:  Data{DataFormatVersion: dataFormatVersion},
+		wake:  make(chan struct{}, 1),
+		store: store,
 	}
 	if err := s.loadPending(); err != nil {
 		return err
 	}
+	if *username != "" {
+		creds, err := flagCredentials()
+		if err != nil {
+			return err
+		}
+		if creds == nil {
+			return errors.New("need one of -pass-env, -pass-file, -secrets or -pass for -user")
+		}
+		u := s.addUser(*username, creds)
+		if _, err := u.golfer(); err != nil {
+			return err
+		}
+	}
+	if len(s.Users) == 0 {
+		return errors.New("need to specify -user and their password for the first account")
+	}
+	// Create the session key up front so sessions survive restarts.
+	s.key()
+	if err := s.savePending(); err != nil {
+		return err
+	}
 
-	g, err := golfer.New(*username, *password)
-	if err != nil {
+	if err := s.loadClubs(); err != nil {
 		return err
 	}
-	s.g = g
 
 	sch := cron.New()
 	if err := sch.AddFunc("@midnight", s.attemptBooking); err != nil {
@@ -160,14 +198,12 @@ func newServer() error {
 	defer sch.Stop()
 	spew.Dump(sch.Entries())
 
-	mux := http.NewServeMux()
-
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static/"))))
-	mux.HandleFunc("/reserve", s.handleReserve)
-	mux.HandleFunc("/cancel", s.handleCancelReservation)
-	mux.HandleFunc("/", s.handleIndex)
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.runSniper(stop)
+	go s.runWatcher(stop)
 
-	handler := handlers.CombinedLoggingHandler(os.Stderr, mux)
+	handler := handlers.CombinedLoggingHandler(os.Stderr, s.routes())
 
 	log.Printf("Listening %s...", *bind)
 	if err := http.ListenAndServe(*bind, handler); err != nil {
@@ -177,7 +213,151 @@ func newServer() error {
 	return nil
 }
 
-func (s *server) handleReserve(w http.ResponseWriter, r *http.Request) {
+// loadClubs fetches the clubs listed in -clubs, their courses and the
+// affiliation types guests and members can be booked with, using the first
+// user's session.
+func (s *server) loadClubs() error {
+	g, err := s.Users[0].golfer()
+	if err != nil {
+		return err
+	}
+	s.clubs = nil
+	s.courses = map[int][]golfer.Course{}
+	s.guestTypes = map[int][]golfer.AffiliationType{}
+	s.memberTypes = map[int][]golfer.AffiliationType{}
+	for _, field := range strings.Split(*clubIDs, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return errors.Wrapf(err, "invalid -clubs")
+		}
+		club, err := g.Club(id)
+		if err != nil {
+			return err
+		}
+		courses, err := g.Courses(id)
+		if err != nil {
+			return err
+		}
+		types, err := g.AffiliationTypes(id)
+		if err != nil {
+			return err
+		}
+		s.clubs = append(s.clubs, club)
+		s.courses[id] = courses
+		for _, t := range types {
+			if t.Public {
+				s.guestTypes[id] = append(s.guestTypes[id], t)
+			} else {
+				s.memberTypes[id] = append(s.memberTypes[id], t)
+			}
+		}
+	}
+	if len(s.clubs) == 0 {
+		return errors.New("need to specify at least one club in -clubs")
+	}
+	return nil
+}
+
+func (s *server) club(id int) (golfer.Club, bool) {
+	for _, c := range s.clubs {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return golfer.Club{}, false
+}
+
+func (s *server) hasCourse(clubID, courseID int) bool {
+	for _, c := range s.courses[clubID] {
+		if c.ID == courseID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) isGuestType(clubID, affiliationTypeID int) bool {
+	for _, t := range s.guestTypes[clubID] {
+		if t.ID == affiliationTypeID {
+			return true
+		}
+	}
+	return false
+}
+
+// affiliationType returns the guest or member affiliation type with id at any
+// of the clubs.
+func (s *server) affiliationType(id int) (golfer.AffiliationType, bool) {
+	for _, types := range []map[int][]golfer.AffiliationType{s.guestTypes, s.memberTypes} {
+		for _, ts := range types {
+			for _, t := range ts {
+				if t.ID == id {
+					return t, true
+				}
+			}
+		}
+	}
+	return golfer.AffiliationType{}, false
+}
+
+// affiliationTypeNames returns the names of the guest and member affiliation
+// types at all of the clubs by ID.
+func (s *server) affiliationTypeNames() map[int]string {
+	names := map[int]string{}
+	for _, types := range []map[int][]golfer.AffiliationType{s.guestTypes, s.memberTypes} {
+		for _, ts := range types {
+			for _, t := range ts {
+				names[t.ID] = t.Name
+			}
+		}
+	}
+	return names
+}
+
+// checkPlayerType checks p can be booked at a club with the affiliation type
+// id: one of the club's guest types, or one of its member types if p is a
+// member.
+func (s *server) checkPlayerType(clubID int, p golfer.Player, id int) error {
+	if s.isGuestType(clubID, id) {
+		return nil
+	}
+	for _, t := range s.memberTypes[clubID] {
+		if t.ID != id {
+			continue
+		}
+		if p.MemberNo == "" && p.UserID == 0 {
+			return errors.Errorf("%s needs a member number or Chronogolf user to be booked at the %s rate", p.Name(), t.Name)
+		}
+		return nil
+	}
+	club, _ := s.club(clubID)
+	return errors.Errorf("affiliation type %d can't be booked at %s", id, club.Name)
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static/"))))
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/signup", s.handleSignup)
+	mux.HandleFunc("/logout", s.authed(s.handleLogout))
+	mux.HandleFunc("/reserve", s.authed(s.handleReserve))
+	mux.HandleFunc("/reserve/preview", s.authed(s.handlePreview))
+	mux.HandleFunc("/reserve/now", s.authed(s.handleBookNow))
+	mux.HandleFunc("/cancel", s.authed(s.handleCancelReservation))
+	mux.HandleFunc("/pending/", s.authed(s.handlePending))
+	mux.HandleFunc("/reservations/", s.authed(s.handleReservation))
+	mux.HandleFunc("/buddies", s.authed(s.handleBuddies))
+	mux.HandleFunc("/buddies/", s.authed(s.handleBuddy))
+	mux.HandleFunc("/history", s.authed(s.handleHistory))
+	mux.HandleFunc("/teetimes", s.authed(s.handleTeeTimes))
+	s.apiRoutes(mux)
+	mux.HandleFunc("/", s.authed(s.handleIndex))
+
+	return mux
+}
+
+func (s *server) handleReserve(w http.ResponseWriter, r *http.Request, u *User) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "must use post", 400)
 		return
@@ -188,42 +368,220 @@ func (s *server) handleReserve(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	date, err := parseDate(r.FormValue("date"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pr, err := s.parseReserveForm(r.Form, u)
 	if err != nil {
-		http.Error(w, "invalid date value: "+err.Error(), 400)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if status, err := s.addPending(u, pr); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+}
+
+// handleBookNow books a reservation on a day that's already open before
+// responding, showing what was booked or why nothing was.
+func (s *server) handleBookNow(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "must use post", 400)
 		return
 	}
-	players, err := strconv.Atoi(r.FormValue("players"))
+
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "invalid players value: "+err.Error(), 400)
+		http.Error(w, "invalid form: "+err.Error(), 400)
 		return
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	pr := PendingReservation{
-		Day:     date.Format(golfer.DateFormat),
-		Players: players,
+	pr, err := s.parseReserveForm(r.Form, u)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
 	}
-	for _, p := range s.Pending {
-		if p == pr {
-			http.Error(w, "reservation already exists", 400)
-			return
-		}
+	result, status, err := s.bookNow(u, pr)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	club, _ := s.club(pr.ClubID)
+	renderMarkdown(w, "booknow.md", struct {
+		Request *PendingReservation
+		Club    golfer.Club
+		Result  bookNowResult
+	}{
+		Request: pr,
+		Club:    club,
+		Result:  result,
+	})
+}
+
+// addPending adds pr to u's pending reservations and starts booking it. It
+// returns the status code to fail the request with on errors.
+func (s *server) addPending(u *User, pr *PendingReservation) (int, error) {
+	pr.setState(StateWaiting, nil)
+
+	if u.hasRequest(pr) {
+		return 409, errors.New("reservation already exists")
 	}
-	s.Pending = append(s.Pending, pr)
+	u.Pending = append(u.Pending, pr)
 	if err := s.savePending(); err != nil {
-		http.Error(w, fmt.Sprintf("failed to save pending: %+v", err), 500)
+		return 500, errors.Wrap(err, "failed to save pending")
+	}
+
+	s.wakeSniper()
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		s.attemptBooking()
+	}()
+	return 0, nil
+}
+
+// handlePreview quotes the price of a new pending reservation so it can be
+// confirmed before it's saved.
+func (s *server) handlePreview(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "must use post", 400)
 		return
 	}
 
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form: "+err.Error(), 400)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pr, err := s.parseReserveForm(r.Form, u)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	club, _ := s.club(pr.ClubID)
+	q, err := s.quote(u, *pr)
+	bookable, _ := dateIsBookable(pr.Day)
+	renderMarkdown(w, "preview.md", struct {
+		Pending  *PendingReservation
+		Club     golfer.Club
+		Form     url.Values
+		Quote    quote
+		QuoteErr error
+		Bookable bool
+	}{
+		Pending:  pr,
+		Club:     club,
+		Form:     r.PostForm,
+		Quote:    q,
+		QuoteErr: err,
+		Bookable: bookable,
+	})
+}
+
+// parseReserveForm builds a new pending reservation from the reservation
+// form.
+func (s *server) parseReserveForm(form url.Values, u *User) (*PendingReservation, error) {
+	date, err := parseDate(form.Get("date"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid date value")
+	}
+	club := s.clubs[0]
+	if v := form.Get("club"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid club value")
+		}
+		var ok bool
+		if club, ok = s.club(id); !ok {
+			return nil, errors.New("unknown club")
+		}
+	}
+
+	var courseIDs []int
+	for _, v := range form["course"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid course value")
+		}
+		if !s.hasCourse(club.ID, id) {
+			return nil, errors.Errorf("course %d isn't at %s", id, club.Name)
+		}
+		courseIDs = append(courseIDs, id)
+	}
+
+	var holes int
+	if v := form.Get("holes"); v != "" {
+		if holes, err = strconv.Atoi(v); err != nil {
+			return nil, errors.Wrap(err, "invalid holes value")
+		}
+	}
+
+	var guestType int
+	if v := form.Get("guest_type"); v != "" {
+		if guestType, err = strconv.Atoi(v); err != nil {
+			return nil, errors.Wrap(err, "invalid guest_type value")
+		}
+		if !s.isGuestType(club.ID, guestType) {
+			return nil, errors.Errorf("affiliation type %d can't be booked at %s", guestType, club.Name)
+		}
+	}
+
+	pr := &PendingReservation{
+		ID:                     newID(),
+		ClubID:                 club.ID,
+		CourseIDs:              courseIDs,
+		Day:                    date.Format(golfer.DateFormat),
+		Holes:                  holes,
+		GuestAffiliationTypeID: guestType,
+	}
+	if err := s.validateHoles(pr); err != nil {
+		return nil, err
+	}
+	if err := parseWindowForm(form, pr); err != nil {
+		return nil, err
+	}
+	if pr.Guests, err = s.parseGuestsForm(form, u, club.ID, pr.Players); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// validateHoles checks the holes of p can be played on every course it
+// lists, or on at least one course at the club if it doesn't list any.
+func (s *server) validateHoles(p *PendingReservation) error {
+	if len(p.CourseIDs) > 0 {
+		for _, id := range p.CourseIDs {
+			for _, c := range s.courses[p.ClubID] {
+				if c.ID != id {
+					continue
+				}
+				if err := c.ValidateHoles(p.Holes); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
 
-	go s.attemptBooking()
+	err := errors.Errorf("no courses found at club %d", p.ClubID)
+	for _, c := range s.courses[p.ClubID] {
+		if err = c.ValidateHoles(p.Holes); err == nil {
+			return nil
+		}
+	}
+	return err
 }
 
-func (s *server) handleCancelReservation(w http.ResponseWriter, r *http.Request) {
+func (s *server) handleCancelReservation(w http.ResponseWriter, r *http.Request, u *User) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "must use post", 400)
 		return
@@ -232,7 +590,7 @@ func (s *server) handleCancelReservation(w http.ResponseWriter, r *http.Request)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.Pending = nil
+	u.Pending = nil
 	if err := s.savePending(); err != nil {
 		http.Error(w, fmt.Sprintf("failed to save pending: %+v", err), 500)
 		return
@@ -241,102 +599,317 @@ func (s *server) handleCancelReservation(w http.ResponseWriter, r *http.Request)
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
-func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+// handlePending handles the actions on a single pending reservation at
+// /pending/{id}/{delete,update,pause,resume}.
+func (s *server) handlePending(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "must use post", 400)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/pending/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	id, action := parts[0], parts[1]
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form: "+err.Error(), 400)
+		return
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	reservations, err := s.g.Reservations()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("%+v", err), 500)
+	p := u.findPending(id)
+	if p == nil {
+		http.Error(w, "unknown pending reservation", 404)
 		return
 	}
-	renderMarkdown(w, "index.md", struct {
-		Reservations []golfer.Reservation
-		Pending      []PendingReservation
-		DefaultDay   string
-	}{
-		Reservations: reservations,
-		Pending:      s.Pending,
-		DefaultDay:   furthestBookingTime(),
-	})
+	if status, err := s.pendingAction(u, p, action, r.Form); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
-func (s *server) attemptBooking() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// pendingAction deletes, updates, pauses or resumes p. Updates take the new
+// party and time window from form. It returns the status code to fail the
+// request with on errors.
+func (s *server) pendingAction(u *User, p *PendingReservation, action string, form url.Values) (int, error) {
+	if p.State == StateAttempting {
+		return 409, errors.New("reservation is being booked")
+	}
 
-	log.Println("Attemping booking!")
-	var pending []PendingReservation
-	for _, p := range s.Pending {
-		can, err := dateIsBookable(p.Day)
-		if err != nil {
-			log.Printf("%+v", err)
-			continue
+	switch action {
+	case "delete":
+		u.removePending(p)
+
+	case "update":
+		if p.State == StateBooked {
+			return 400, errors.New("reservation is already booked")
 		}
-		if !can {
-			pending = append(pending, p)
-			continue
+		updated := *p
+		if err := parseWindowForm(form, &updated); err != nil {
+			return 400, err
 		}
-		if err := s.bookFirst(p.Day, p.Players); err != nil {
-			log.Printf("%+v", err)
-			continue
+		if updated.State == StateExpired {
+			updated.setState(StateWaiting, nil)
+			updated.expire()
 		}
-	}
-	if len(pending) != len(s.Pending) {
-		s.Pending = pending
-		if err := s.savePending(); err != nil {
-			log.Printf("%+v", err)
-			return
+		*p = updated
+
+	case "pause":
+		if !p.Active() {
+			return 400, errors.Errorf("can't pause %s reservation", p.State)
+		}
+		p.setState(StatePaused, nil)
+
+	case "resume":
+		if p.State != StatePaused {
+			return 400, errors.Errorf("can't resume %s reservation", p.State)
 		}
+		p.setState(StateWaiting, nil)
+		p.expire()
+
+	default:
+		return 404, errors.Errorf("unknown action %q", action)
 	}
+
+	if err := s.savePending(); err != nil {
+		return 500, errors.Wrap(err, "failed to save pending")
+	}
+	s.wakeSniper()
+	return 0, nil
 }
 
-func (s *server) bookFirst(day string, players int) error {
-	af, err := s.g.Affiliation()
+// parseWindowForm fills in the party and time window of p from a form.
+func parseWindowForm(form url.Values, p *PendingReservation) error {
+	players, err := strconv.Atoi(form.Get("players"))
 	if err != nil {
-		return err
+		return errors.Wrap(err, "invalid players value")
+	}
+	if players < 1 || players > maxPlayers {
+		return errors.Errorf("invalid players value: must be between 1 and %d", maxPlayers)
 	}
-	c, err := s.g.Course()
+	if len(p.Guests) > players-1 {
+		return errors.Errorf("invalid players value: %d guests don't fit in %d players", len(p.Guests), players)
+	}
+	preference, err := parsePreference(form.Get("preference"))
 	if err != nil {
-		return err
+		return errors.Wrap(err, "invalid preference value")
 	}
-	target, err := parseDate(day)
+	target, err := parseDate(p.Day)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "invalid date value")
+	}
+
+	p.Players = players
+	p.Preference = preference
+	p.Watch = form.Get("watch") != ""
+	p.Earliest = form.Get("earliest")
+	if p.Earliest == "" {
+		p.Earliest = target.Format(TimeFormat)
 	}
+	p.Latest = form.Get("latest")
+	if p.Latest == "" {
+		p.Latest = "23:59"
+	}
+	if _, _, _, err := p.window(); err != nil {
+		return errors.Wrap(err, "invalid time window")
+	}
+	return nil
+}
 
-	tts, err := s.g.TeeTimes(af, c, day, players)
+// handleReservation handles /reservations/{id}/cancel, showing a
+// confirmation page on GET and canceling the Chronogolf reservation on POST.
+func (s *server) handleReservation(w http.ResponseWriter, r *http.Request, u *User) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/reservations/"), "/")
+	if len(parts) != 2 || parts[1] != "cancel" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(parts[0])
 	if err != nil {
-		return err
+		http.Error(w, "invalid reservation id: "+err.Error(), 400)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, err := u.golfer()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%+v", err), 500)
+		return
 	}
 
-	var filteredTT []golfer.TeeTime
-	for _, tt := range tts {
-		t, err := tt.Time()
+	switch r.Method {
+	case http.MethodGet:
+		reservations, err := g.Reservations()
 		if err != nil {
-			return err
+			http.Error(w, fmt.Sprintf("%+v", err), 500)
+			return
 		}
-		if t.Before(target) {
-			continue
+		for _, res := range reservations {
+			if res.ID == id {
+				renderMarkdown(w, "cancel.md", struct {
+					golfer.Reservation
+					CSRF string
+				}{res, s.csrfToken(r)})
+				return
+			}
+		}
+		http.Error(w, "unknown reservation", 404)
+
+	case http.MethodPost:
+		if _, err := g.CancelReservation(id); err != nil {
+			http.Error(w, fmt.Sprintf("failed to cancel reservation: %+v", err), 500)
+			return
 		}
-		filteredTT = append(filteredTT, tt)
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+
+	default:
+		http.Error(w, "must use get or post", 400)
 	}
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request, u *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if len(filteredTT) == 0 {
-		return errors.New("no tee times found")
+	g, err := u.golfer()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%+v", err), 500)
+		return
 	}
-	firstTT := filteredTT[0]
-	log.Printf("reserving %+v", firstTT)
-	if _, err := s.g.Reserve(af, c, firstTT, players); err != nil {
-		return err
+	reservations, err := g.Reservations()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%+v", err), 500)
+		return
+	}
+	clubNames := map[int]string{}
+	for _, c := range s.clubs {
+		clubNames[c.ID] = c.Name
+	}
+	courseNames := map[int]string{}
+	for _, courses := range s.courses {
+		for _, c := range courses {
+			courseNames[c.ID] = c.Name
+		}
+	}
+	day := furthestBookingTime()
+	renderMarkdown(w, "index.md", struct {
+		User            string
+		CSRF            string
+		Reservations    []golfer.Reservation
+		Pending         []*PendingReservation
+		Buddies         []*Buddy
+		History         []Booking
+		Clubs           []golfer.Club
+		ClubNames       map[int]string
+		Courses         map[int][]golfer.Course
+		CourseNames     map[int]string
+		GuestTypes      map[int][]golfer.AffiliationType
+		MemberTypes     map[int][]golfer.AffiliationType
+		GuestTypeNames  map[int]string
+		Preferences     []Preference
+		DefaultDay      string
+		DefaultEarliest string
+		DefaultLatest   string
+	}{
+		User:            u.Name,
+		CSRF:            s.csrfToken(r),
+		Reservations:    reservations,
+		Pending:         u.Pending,
+		Buddies:         u.Buddies,
+		History:         u.History,
+		Clubs:           s.clubs,
+		ClubNames:       clubNames,
+		Courses:         s.courses,
+		CourseNames:     courseNames,
+		GuestTypes:      s.guestTypes,
+		MemberTypes:     s.memberTypes,
+		GuestTypeNames:  s.affiliationTypeNames(),
+		Preferences:     preferences,
+		DefaultDay:      day.Format(golfer.DateFormat),
+		DefaultEarliest: day.Add(-defaultWindow).Format(TimeFormat),
+		DefaultLatest:   day.Add(defaultWindow).Format(TimeFormat),
+	})
+}
+
+func (s *server) attemptBooking() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.Println("Attemping booking!")
+	var due []*PendingReservation
+	for _, u := range s.Users {
+		for _, p := range u.Pending {
+			if p.expire() || !p.Active() {
+				continue
+			}
+			can, err := dateIsBookable(p.Day)
+			if err != nil {
+				log.Printf("%+v", err)
+				p.setState(StateFailed, err)
+				continue
+			}
+			if can {
+				due = append(due, p)
+			}
+		}
+	}
+	// s.mu is released while each reservation is booked, so the ones after
+	// it may have been changed or deleted in the meantime.
+	for _, p := range due {
+		u := s.owner(p)
+		if u == nil || !p.Active() {
+			continue
+		}
+		if _, err := s.attempt(u, p); err != nil {
+			log.Printf("%s: %+v", u.Name, err)
+		}
+	}
+	for _, u := range s.Users {
+		u.prunePending()
+	}
+	if err := s.savePending(); err != nil {
+		log.Printf("%+v", err)
 	}
-	return nil
 }
 
 func main() {
 	log.SetFlags(log.Flags() | log.Lshortfile)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), commandUsage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "flog: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *migrateStore != "" {
+		if err := copyStore(); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		log.Printf("copied %s to %s, the booking history stays in %s", *saveFile, *migrateFile, historyPath())
+		return
+	}
+	if *addSecret {
+		if err := addSecretFromStdin(); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
 	if err := newServer(); err != nil {
 		log.Fatalf("%+v", err)
 	}
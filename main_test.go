@@ -1,8 +1,20 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/d4l3k/flog/golfer/golfertest"
 )
 
 func TestDateIsBookable(t *testing.T) {
@@ -28,3 +40,470 @@ func TestDateIsBookable(t *testing.T) {
 		}
 	}
 }
+
+func TestRankTeeTimes(t *testing.T) {
+	tts := []golfer.TeeTime{
+		{ID: 1, Date: "2018-05-17", StartTime: "06:50"},
+		{ID: 2, Date: "2018-05-17", StartTime: "07:00"},
+		{ID: 3, Date: "2018-05-17", StartTime: "07:20"},
+		{ID: 4, Date: "2018-05-17", StartTime: "07:40"},
+		{ID: 5, Date: "2018-05-17", StartTime: "14:00"},
+	}
+	cases := []struct {
+		preference Preference
+		want       []int
+	}{
+		{PreferClosest, []int{2, 3, 4}},
+		{PreferEarliest, []int{2, 3, 4}},
+		{PreferLatest, []int{4, 3, 2}},
+	}
+
+	for i, c := range cases {
+		p := PendingReservation{
+			Day:        "2018-05-17T07:10",
+			Earliest:   "07:00",
+			Latest:     "08:00",
+			Preference: c.preference,
+		}
+		out, err := rankTeeTimes(p, tts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, tt := range out {
+			ids = append(ids, tt.ID)
+		}
+		if !reflect.DeepEqual(ids, c.want) {
+			t.Errorf("%d. rankTeeTimes(%q) = %v; not %v", i, c.preference, ids, c.want)
+		}
+	}
+}
+
+func TestPendingExpire(t *testing.T) {
+	cases := []struct {
+		state State
+		now   time.Time
+		want  State
+	}{
+		{StateWaiting, time.Date(2018, 05, 17, 7, 0, 0, 0, time.Local), StateWaiting},
+		{StateFailed, time.Date(2018, 05, 17, 8, 0, 0, 0, time.Local), StateFailed},
+		{StateFailed, time.Date(2018, 05, 17, 8, 1, 0, 0, time.Local), StateExpired},
+		{StateWaiting, time.Date(2018, 05, 18, 0, 0, 0, 0, time.Local), StateExpired},
+		{StateBooked, time.Date(2018, 05, 18, 0, 0, 0, 0, time.Local), StateBooked},
+	}
+
+	for i, c := range cases {
+		now = func() time.Time {
+			return c.now
+		}
+		p := &PendingReservation{
+			Day:      "2018-05-17T07:10",
+			Earliest: "07:00",
+			Latest:   "08:00",
+			State:    c.state,
+		}
+		p.expire()
+		if p.State != c.want {
+			t.Errorf("%d. expire() at %s = %s; not %s", i, c.now, p.State, c.want)
+		}
+	}
+}
+
+func TestHandlePending(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2018, 05, 10, 0, 0, 0, 0, time.Local)
+	}
+	oldSaveFile := *saveFile
+	*saveFile = filepath.Join(t.TempDir(), "flog.data")
+	defer func() { *saveFile = oldSaveFile }()
+
+	waiting := &PendingReservation{ID: "waiting", Day: "2018-05-17T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateWaiting}
+	attempting := &PendingReservation{ID: "attempting", Day: "2018-05-17T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateAttempting}
+	booked := &PendingReservation{ID: "booked", Day: "2018-05-17T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateBooked}
+	s := &server{Data: Data{DataFormatVersion: dataFormatVersion}}
+	u := s.addUser(golfertest.User, golfer.Password(golfertest.Password))
+	u.Pending = []*PendingReservation{waiting, attempting, booked}
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.handlePending(w, req, u)
+		return w
+	}
+
+	window := url.Values{"players": {"3"}, "earliest": {"07:30"}, "latest": {"09:00"}, "preference": {string(PreferLatest)}}
+	cases := []struct {
+		path  string
+		form  url.Values
+		code  int
+		state State
+	}{
+		{"/pending/waiting/update", window, http.StatusTemporaryRedirect, StateWaiting},
+		{"/pending/waiting/update", url.Values{"players": {"5"}}, http.StatusBadRequest, StateWaiting},
+		{"/pending/waiting/update", url.Values{"players": {"2"}, "earliest": {"09:00"}, "latest": {"08:00"}}, http.StatusBadRequest, StateWaiting},
+		{"/pending/waiting/resume", nil, http.StatusBadRequest, StateWaiting},
+		{"/pending/waiting/pause", nil, http.StatusTemporaryRedirect, StatePaused},
+		{"/pending/waiting/pause", nil, http.StatusBadRequest, StatePaused},
+		{"/pending/waiting/resume", nil, http.StatusTemporaryRedirect, StateWaiting},
+		{"/pending/waiting/explode", nil, http.StatusNotFound, StateWaiting},
+	}
+	for i, c := range cases {
+		if w := post(c.path, c.form); w.Code != c.code || waiting.State != c.state {
+			t.Errorf("%d. POST %s = %d, %s; not %d, %s: %s", i, c.path, w.Code, waiting.State, c.code, c.state, w.Body)
+		}
+	}
+	if waiting.Players != 3 || waiting.Earliest != "07:30" || waiting.Latest != "09:00" || waiting.Preference != PreferLatest {
+		t.Errorf("updated = %+v", waiting)
+	}
+
+	for _, action := range []string{"delete", "update", "pause", "resume"} {
+		if w := post("/pending/attempting/"+action, window); w.Code != http.StatusConflict {
+			t.Errorf("POST %s on an attempting reservation = %d", action, w.Code)
+		}
+	}
+	if w := post("/pending/booked/update", window); w.Code != http.StatusBadRequest || booked.Players != 2 {
+		t.Errorf("POST update on a booked reservation = %d: %+v", w.Code, booked)
+	}
+	if w := post("/pending/nobody/delete", nil); w.Code != http.StatusNotFound {
+		t.Errorf("POST delete on an unknown reservation = %d", w.Code)
+	}
+	w := httptest.NewRecorder()
+	s.handlePending(w, httptest.NewRequest("GET", "/pending/waiting/delete", nil), u)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("GET delete = %d", w.Code)
+	}
+
+	if w := post("/pending/waiting/delete", nil); w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("POST delete = %d: %s", w.Code, w.Body)
+	}
+	if len(u.Pending) != 2 || u.findPending("waiting") != nil {
+		t.Errorf("Pending after delete = %+v", u.Pending)
+	}
+	loaded := server{}
+	if err := loaded.loadPending(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Users) != 1 || len(loaded.Users[0].Pending) != 2 || loaded.Users[0].findPending("booked") == nil {
+		t.Errorf("saved users = %+v", loaded.Users)
+	}
+}
+
+func TestLoadPendingBackfillsIDs(t *testing.T) {
+	oldSaveFile := *saveFile
+	*saveFile = filepath.Join(t.TempDir(), "flog.data")
+	defer func() { *saveFile = oldSaveFile }()
+
+	data := fmt.Sprintf(`{"DataFormatVersion":%d,"Users":[{"Name":%q,"Pending":[{"Day":"2018-05-17T07:10","Players":2,"State":"waiting"},{"Day":"2018-05-18T07:10","Players":2,"State":"attempting"},{"ID":"kept","Day":"2018-05-19T07:10","Players":2,"State":"waiting"}]}]}`, dataFormatVersion, golfertest.User)
+	if err := ioutil.WriteFile(*saveFile, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := server{}
+	if err := s.loadPending(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Users) != 1 || len(s.Users[0].Pending) != 3 {
+		t.Fatalf("Users = %+v", s.Users)
+	}
+	pending := s.Users[0].Pending
+	a, b := pending[0], pending[1]
+	if a.ID == "" || b.ID == "" || a.ID == b.ID || pending[2].ID != "kept" {
+		t.Errorf("IDs = %q, %q, %q", a.ID, b.ID, pending[2].ID)
+	}
+	if b.State != StateFailed {
+		t.Errorf("interrupted reservation = %+v", b)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	s, fake := newTestServer(t)
+	tt := fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+	h := testHandler(s)
+
+	form := url.Values{
+		"date":       {"2018-05-19T07:10"},
+		"earliest":   {"07:00"},
+		"latest":     {"08:00"},
+		"preference": {"closest"},
+		"players":    {"2"},
+	}
+	req := httptest.NewRequest("POST", "/reserve", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("POST /reserve = %d: %s", w.Code, w.Body)
+	}
+	s.mu.Lock()
+	if len(s.Users[0].Pending) != 1 || s.Users[0].Pending[0].State != StateWaiting {
+		t.Errorf("Pending = %+v", s.Users[0].Pending)
+	}
+	s.mu.Unlock()
+
+	af, err := s.Users[0].g.Affiliation(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.Users[0].g.Course(golfertest.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Users[0].g.Reserve(af, c, tt, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	id := fake.Reservations()[0].ID
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET / = %d: %s", w.Code, w.Body)
+	}
+	for _, want := range []string{"2018-05-19T07:10", "2018-05-17 07:10", fmt.Sprintf("/reservations/%d/cancel", id), `<option value="18">18</option>`, `<option value="9">9</option>`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("GET / missing %q", want)
+		}
+	}
+	// The course can't be played as a double round.
+	if strings.Contains(w.Body.String(), `<option value="36">`) {
+		t.Errorf("GET / offers 36 holes:\n%s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/reservations/%d/cancel", id), nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Cancel Reservation") {
+		t.Fatalf("GET cancel = %d: %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/reservations/%d/cancel", id), nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("POST cancel = %d: %s", w.Code, w.Body)
+	}
+	if state := fake.Reservations()[0].State; state != "canceled" {
+		t.Errorf("reservation state = %q; not canceled", state)
+	}
+}
+
+func TestHandlePreview(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-18", "07:10", 4)
+	h := testHandler(s)
+
+	form := url.Values{
+		"date":    {"2018-05-19T07:10"},
+		"players": {"2"},
+		"holes":   {"9"},
+	}
+	req := httptest.NewRequest("POST", "/reserve/preview", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /reserve/preview = %d: %s", w.Code, w.Body)
+	}
+	for _, want := range []string{"estimated", "for 9 holes", "Total: $50.00", `name="holes" value="9"`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("preview missing %q:\n%s", want, w.Body)
+		}
+	}
+	if len(s.Users[0].Pending) != 0 {
+		t.Errorf("preview saved pending reservations: %+v", s.Users[0].Pending)
+	}
+
+	form.Set("guest_type", strconv.Itoa(golfertest.PublicAffiliationTypeID))
+	req = httptest.NewRequest("POST", "/reserve/preview", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if want := "Total: $65.00"; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("preview with a guest rate missing %q:\n%s", want, w.Body)
+	}
+
+	for _, c := range []struct {
+		key, value string
+	}{
+		{"holes", "36"},
+		{"guest_type", strconv.Itoa(golfertest.MemberAffiliationTypeID)},
+	} {
+		bad := url.Values{}
+		for k, v := range form {
+			bad[k] = v
+		}
+		bad.Set(c.key, c.value)
+		req = httptest.NewRequest("POST", "/reserve/preview", strings.NewReader(bad.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("POST /reserve/preview with %s=%s = %d: %s", c.key, c.value, w.Code, w.Body)
+		}
+	}
+}
+
+func TestBuddies(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+	h := testHandler(s)
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("/buddies", url.Values{"first": {"Jane"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /buddies without a last name = %d", w.Code)
+	}
+	if w := post("/buddies", url.Values{"first": {"Jane"}, "last": {"Doe"}, "member": {"1234"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /buddies = %d: %s", w.Code, w.Body)
+	}
+	if len(s.Users[0].Buddies) != 1 {
+		t.Fatalf("Buddies = %+v", s.Users[0].Buddies)
+	}
+	buddy := s.Users[0].Buddies[0]
+
+	form := url.Values{
+		"date":    {"2018-05-17T07:10"},
+		"players": {"2"},
+		"guest":   {buddy.ID},
+	}
+	if w := post("/reserve", form); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("POST /reserve = %d: %s", w.Code, w.Body)
+	}
+	if w := post("/buddies/"+buddy.ID+"/delete", nil); w.Code != http.StatusSeeOther {
+		t.Fatalf("POST delete buddy = %d: %s", w.Code, w.Body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.Users[0].Buddies) != 0 {
+		t.Errorf("Buddies after delete = %+v", s.Users[0].Buddies)
+	}
+	if len(s.Users[0].Pending) != 1 || len(s.Users[0].Pending[0].Guests) != 1 || s.Users[0].Pending[0].Guests[0].MemberNo != "1234" {
+		t.Fatalf("Pending = %+v", s.Users[0].Pending)
+	}
+	if _, err := s.bookFirst(testGolfer(t, s.Users[0]), *s.Users[0].Pending[0]); err != nil && err != errNoTeeTimes {
+		t.Fatal(err)
+	}
+}
+
+func TestBuddyAffiliationTypes(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+	h := testHandler(s)
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	member, public := strconv.Itoa(golfertest.MemberAffiliationTypeID), strconv.Itoa(golfertest.PublicAffiliationTypeID)
+	for _, form := range []url.Values{
+		{"first": {"Gus"}, "last": {"Guest"}, "affiliation_type": {"guest"}},
+		{"first": {"Gus"}, "last": {"Guest"}, "affiliation_type": {member}},
+		{"first": {"Gus"}, "last": {"Guest"}, "affiliation_type": {"999"}},
+	} {
+		if w := post("/buddies", form); w.Code != http.StatusBadRequest {
+			t.Errorf("POST /buddies %v = %d", form, w.Code)
+		}
+	}
+	for _, form := range []url.Values{
+		{"first": {"Mia"}, "last": {"Member"}, "member": {"42"}, "affiliation_type": {member}},
+		{"first": {"Gus"}, "last": {"Guest"}},
+	} {
+		if w := post("/buddies", form); w.Code != http.StatusSeeOther {
+			t.Fatalf("POST /buddies %v = %d: %s", form, w.Code, w.Body)
+		}
+	}
+	s.mu.Lock()
+	buddies := s.Users[0].Buddies
+	s.mu.Unlock()
+	if len(buddies) != 2 || buddies[0].AffiliationTypeID != golfertest.MemberAffiliationTypeID {
+		t.Fatalf("Buddies = %+v", buddies)
+	}
+	mia, gus := buddies[0].ID, buddies[1].ID
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/buddies", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "Member rate") {
+		t.Errorf("GET /buddies = %d: %s", w.Code, w.Body)
+	}
+
+	form := url.Values{
+		"date":       {"2018-05-17T07:10"},
+		"players":    {"3"},
+		"guest":      {mia, gus},
+		"guest_type": {public},
+	}
+	if w := post("/reserve", form); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("POST /reserve = %d: %s", w.Code, w.Body)
+	}
+	s.background.Wait()
+
+	reservations := fake.Reservations()
+	if len(reservations) != 1 || len(reservations[0].Rounds) != 3 {
+		t.Fatalf("reservations = %+v", reservations)
+	}
+	want := []int{golfertest.MemberAffiliationTypeID, golfertest.MemberAffiliationTypeID, golfertest.PublicAffiliationTypeID}
+	for i, round := range reservations[0].Rounds {
+		if round.AffiliationTypeID != want[i] {
+			t.Errorf("round %d is priced as %d; not %d", i, round.AffiliationTypeID, want[i])
+		}
+	}
+	if member := reservations[0].Rounds[1].Customer; member.MemberNo != "42" {
+		t.Errorf("member round customer = %+v", member)
+	}
+}
+
+func TestGuestTypes(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Users[0].Buddies = []*Buddy{
+		{ID: "mia", Player: golfer.Player{FirstName: "Mia", LastName: "Member", MemberNo: "42", AffiliationTypeID: golfertest.MemberAffiliationTypeID}},
+		{ID: "gus", Player: golfer.Player{FirstName: "Gus", LastName: "Guest"}},
+	}
+	h := testHandler(s)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/reserve", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	member, public := strconv.Itoa(golfertest.MemberAffiliationTypeID), strconv.Itoa(golfertest.PublicAffiliationTypeID)
+	for _, types := range []url.Values{
+		{"guest_type.gus": {member}},
+		{"guest_type.gus": {"999"}},
+		{"guest_type.gus": {"public"}},
+	} {
+		form := url.Values{"date": {"2018-05-25T07:10"}, "players": {"3"}, "guest": {"mia", "gus"}}
+		for k, v := range types {
+			form[k] = v
+		}
+		if w := post(form); w.Code != http.StatusBadRequest {
+			t.Errorf("POST /reserve with %v = %d: %s", types, w.Code, w.Body)
+		}
+	}
+
+	form := url.Values{"date": {"2018-05-25T07:10"}, "players": {"3"}, "guest": {"mia", "gus"}, "guest_type.mia": {public}, "guest_type.gus": {public}}
+	if w := post(form); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("POST /reserve = %d: %s", w.Code, w.Body)
+	}
+	s.mu.Lock()
+	guests := s.Users[0].Pending[0].Guests
+	s.mu.Unlock()
+	if len(guests) != 2 || guests[0].AffiliationTypeID != golfertest.PublicAffiliationTypeID || guests[1].AffiliationTypeID != golfertest.PublicAffiliationTypeID {
+		t.Errorf("Guests = %+v", guests)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if w.Code != 200 || !strings.Contains(body, `name="guest_type.gus"`) || !strings.Contains(body, "Gus Guest (Public)") {
+		t.Errorf("GET / = %d: %s", w.Code, body)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+// migrations upgrade the data file from the version they're keyed by to the
+// next one. Adding a migration means bumping dataFormatVersion.
+var migrations = map[int]func(data map[string]interface{}) error{
+	1: migrateV1,
+	2: migrateV2,
+	3: migrateV3,
+}
+
+// migrate upgrades data read from path to dataFormatVersion one version at a
+// time, backing it up as it was first. Data from newer versions of flog is
+// refused since it can't be downgraded.
+func migrate(data map[string]interface{}, path string) error {
+	v, _ := data["DataFormatVersion"].(float64)
+	version := int(v)
+	if version == dataFormatVersion {
+		return nil
+	}
+	if version > dataFormatVersion {
+		return errors.Errorf("flog data file is version %d but this flog only understands up to version %d, upgrade flog", version, dataFormatVersion)
+	}
+	if migrations[version] == nil {
+		return errors.Errorf("unknown flog data file version %d", version)
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(migrationBackupPath(path, version), b); err != nil {
+		return errors.Wrap(err, "backing up data file before migrating")
+	}
+
+	for ; version < dataFormatVersion; version++ {
+		m := migrations[version]
+		if m == nil {
+			return errors.Errorf("no migration from flog data file version %d", version)
+		}
+		log.Printf("Migrating flog data file from version %d.", version)
+		if err := m(data); err != nil {
+			return errors.Wrapf(err, "migrating from version %d", version)
+		}
+		data["DataFormatVersion"] = version + 1
+	}
+	return nil
+}
+
+// migrationBackupPath is where the data at path is backed up before migrating
+// it from version.
+func migrationBackupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// migrateV1 adds time windows to pending reservations. Version 1 booked the
+// first tee time at or after Day so that's what the window is set to.
+func migrateV1(data map[string]interface{}) error {
+	pending, _ := data["Pending"].([]interface{})
+	for _, v := range pending {
+		p, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("invalid pending reservation %+v", v)
+		}
+		day, _ := p["Day"].(string)
+		t, err := parseDate(day)
+		if err != nil {
+			return err
+		}
+		p["Earliest"] = t.Format(TimeFormat)
+		p["Latest"] = "23:59"
+		p["Preference"] = PreferEarliest
+	}
+	return nil
+}
+
+// migrateV2 adds states to pending reservations. Version 2 dropped requests
+// once they were attempted so everything left is still waiting.
+func migrateV2(data map[string]interface{}) error {
+	pending, _ := data["Pending"].([]interface{})
+	for _, v := range pending {
+		p, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("invalid pending reservation %+v", v)
+		}
+		p["State"] = StateWaiting
+		p["Updated"] = now()
+	}
+	return nil
+}
+
+// migrateV3 moves everything into a per-user list. Version 3 only had a
+// single account, so it's given to the -user account.
+func migrateV3(data map[string]interface{}) error {
+	if *username == "" {
+		return errors.New("need to specify -user to own the existing reservations")
+	}
+	user := map[string]interface{}{
+		"Name": *username,
+	}
+	if *password != "" {
+		user["Password"] = *password
+	}
+	for _, key := range []string{"Pending", "Buddies", "History"} {
+		if v, ok := data[key]; ok {
+			user[key] = v
+			delete(data, key)
+		}
+	}
+	data["Users"] = []interface{}{user}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+func decodeData(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMigrateV1(t *testing.T) {
+	data := decodeData(t, `{"DataFormatVersion":1,"Pending":[{"Day":"2018-05-17T07:10","Players":2}]}`)
+	if err := migrateV1(data); err != nil {
+		t.Fatal(err)
+	}
+	p := data["Pending"].([]interface{})[0].(map[string]interface{})
+	if p["Earliest"] != "07:10" || p["Latest"] != "23:59" || p["Preference"] != PreferEarliest {
+		t.Errorf("migrated = %+v", p)
+	}
+
+	if err := migrateV1(decodeData(t, `{"Pending":[{"Day":"tomorrow"}]}`)); err == nil {
+		t.Errorf("migrating an invalid day succeeded")
+	}
+}
+
+func TestMigrateV2(t *testing.T) {
+	newFakeClock(time.Date(2018, 05, 10, 0, 0, 0, 0, time.Local))
+	data := decodeData(t, `{"DataFormatVersion":2,"Pending":[{"Day":"2018-05-17T07:10","Players":2}]}`)
+	if err := migrateV2(data); err != nil {
+		t.Fatal(err)
+	}
+	p := data["Pending"].([]interface{})[0].(map[string]interface{})
+	if p["State"] != StateWaiting || p["Updated"] != now() {
+		t.Errorf("migrated = %+v", p)
+	}
+}
+
+func TestMigrateV3(t *testing.T) {
+	newTestServer(t)
+	data := `{"DataFormatVersion":3,"Pending":[{"ID":"a","ClubID":17078,"Day":"2018-05-17T07:10","Players":2,"Earliest":"07:00","Latest":"08:00","Preference":"closest","State":"waiting"}],"Buddies":[{"ID":"b","FirstName":"Jane","LastName":"Doe"}]}`
+	if err := ioutil.WriteFile(*saveFile, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&server{}).loadPending(); err == nil {
+		t.Errorf("migrating without -user succeeded")
+	}
+
+	*username, *password = golfertest.User, golfertest.Password
+	defer func() {
+		*username, *password = "", ""
+	}()
+	var s server
+	if err := s.loadPending(); err != nil {
+		t.Fatal(err)
+	}
+	if s.DataFormatVersion != dataFormatVersion || len(s.Users) != 1 {
+		t.Fatalf("loaded version %d, users %+v", s.DataFormatVersion, s.Users)
+	}
+	u := s.Users[0]
+	if u.Name != golfertest.User || u.Password != golfertest.Password || len(u.Pending) != 1 || u.Pending[0].ID != "a" || len(u.Buddies) != 1 {
+		t.Errorf("migrated user = %+v", u)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	newTestServer(t)
+	*username = golfertest.User
+	defer func() { *username = "" }()
+	original := `{"DataFormatVersion":1,"Pending":[{"Day":"2018-05-17T07:10","Players":2}]}`
+	if err := ioutil.WriteFile(*saveFile, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var s server
+	if err := s.loadPending(); err != nil {
+		t.Fatal(err)
+	}
+	if s.DataFormatVersion != dataFormatVersion || len(s.Users) != 1 || len(s.Users[0].Pending) != 1 {
+		t.Fatalf("loaded version %d, users %+v", s.DataFormatVersion, s.Users)
+	}
+	if p := s.Users[0].Pending[0]; p.Earliest != "07:10" || p.State != StateWaiting || p.ID == "" {
+		t.Errorf("migrated = %+v", p)
+	}
+
+	backup, err := ioutil.ReadFile(migrationBackupPath(*saveFile, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decodeData(t, string(backup)), decodeData(t, original); len(got) != len(want) || got["DataFormatVersion"] != want["DataFormatVersion"] {
+		t.Errorf("backup = %s", backup)
+	}
+
+	for _, data := range []string{`{"DataFormatVersion":99}`, `{"Pending":[]}`} {
+		if err := ioutil.WriteFile(*saveFile, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&server{}).loadPending(); err == nil {
+			t.Errorf("loading %s succeeded", data)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/pkg/errors"
+)
+
+// TimeFormat is the format of the time of day bounds on a pending
+// reservation.
+const TimeFormat = "15:04"
+
+// Preference is the order in which tee times inside a pending reservation's
+// window are tried.
+type Preference string
+
+const (
+	PreferClosest  Preference = "closest"
+	PreferEarliest Preference = "earliest"
+	PreferLatest   Preference = "latest"
+)
+
+var preferences = []Preference{PreferClosest, PreferEarliest, PreferLatest}
+
+func parsePreference(s string) (Preference, error) {
+	if s == "" {
+		return PreferClosest, nil
+	}
+	for _, p := range preferences {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", errors.Errorf("unknown preference %q", s)
+}
+
+// State is where a pending reservation is in its life cycle.
+//
+// Requests start out waiting until their day can be booked and can be paused
+// and resumed while they're waiting. Each booking run moves them to
+// attempting and then to booked or, on error, failed. Failed requests are
+// retried on later runs until the window on their day has passed, at which
+// point they're given up on and expired.
+type State string
+
+const (
+	StateWaiting    State = "waiting"
+	StateAttempting State = "attempting"
+	StateBooked     State = "booked"
+	StateFailed     State = "failed"
+	StateExpired    State = "expired"
+	StatePaused     State = "paused"
+)
+
+// keepFinished is how long booked and expired reservations are shown after
+// their day.
+const keepFinished = 7 * 24 * time.Hour
+
+var errNoTeeTimes = errors.New("no tee times found")
+
+type PendingReservation struct {
+	// ID is a stable identifier used to address the reservation in the UI.
+	ID string
+	// ClubID is the Chronogolf club to book at.
+	ClubID int
+	// CourseIDs are the acceptable courses at the club, tried in this order.
+	// The web form lists the checked courses in the order they're on the
+	// page, the API in the order they're given. Any course can be booked if
+	// it's empty.
+	CourseIDs []int `json:",omitempty"`
+	// Holes is the number of holes to book, zero for the course's default.
+	Holes int `json:",omitempty"`
+	// Day is the target tee time formatted as golfer.DateFormat.
+	Day     string
+	Players int
+	// Guests fill the other player slots in order, any left over are booked
+	// as anonymous guests.
+	Guests []golfer.Player `json:",omitempty"`
+	// GuestAffiliationTypeID prices the guests that don't have their own
+	// affiliation type, e.g. a club's public rate. Zero uses the user's.
+	GuestAffiliationTypeID int `json:",omitempty"`
+	// Earliest and Latest are the acceptable start times on Day formatted as
+	// TimeFormat.
+	Earliest   string
+	Latest     string
+	Preference Preference
+	// Watch keeps polling for cancellations once the day is open, booking
+	// the first tee time in the window that frees up for the party.
+	Watch bool `json:",omitempty"`
+
+	State State
+	// Attempts is the number of tee times we've tried to reserve.
+	Attempts  int
+	LastError string `json:",omitempty"`
+	Updated   time.Time
+}
+
+// Active returns whether the reservation should still be booked.
+func (p *PendingReservation) Active() bool {
+	return p.State == StateWaiting || p.State == StateFailed
+}
+
+func (p *PendingReservation) setState(state State, err error) {
+	p.State = state
+	p.LastError = ""
+	if err != nil {
+		p.LastError = err.Error()
+	}
+	p.Updated = now()
+}
+
+// expire gives up on active reservations once the last acceptable tee time
+// has passed. It returns whether p is expired.
+func (p *PendingReservation) expire() bool {
+	if p.State == StateExpired {
+		return true
+	}
+	if !p.Active() {
+		return false
+	}
+	_, _, latest, err := p.window()
+	if err != nil {
+		p.setState(StateExpired, err)
+		return true
+	}
+	if !now().After(latest) {
+		return false
+	}
+	reason := "window passed"
+	if p.LastError != "" {
+		reason += ", last error: " + p.LastError
+	}
+	p.setState(StateExpired, errors.Errorf("gave up after %d attempts: %s", p.Attempts, reason))
+	return true
+}
+
+// sameRequest returns whether o asks for the same booking as p.
+func (p *PendingReservation) sameRequest(o *PendingReservation) bool {
+	return p.ClubID == o.ClubID && reflect.DeepEqual(p.CourseIDs, o.CourseIDs) &&
+		p.Day == o.Day && p.Players == o.Players && p.Earliest == o.Earliest &&
+		p.Latest == o.Latest && p.Preference == o.Preference && p.Holes == o.Holes &&
+		reflect.DeepEqual(p.Guests, o.Guests) && p.GuestAffiliationTypeID == o.GuestAffiliationTypeID
+}
+
+// party returns the players booked alongside the user, with a player for
+// every slot so each one is priced with the right affiliation type.
+func (p PendingReservation) party() []golfer.Player {
+	party := make([]golfer.Player, 0, p.Players)
+	for i := 1; i < p.Players; i++ {
+		var g golfer.Player
+		if i-1 < len(p.Guests) {
+			g = p.Guests[i-1]
+		}
+		if g.AffiliationTypeID == 0 {
+			g.AffiliationTypeID = p.GuestAffiliationTypeID
+		}
+		party = append(party, g)
+	}
+	return party
+}
+
+// finished returns whether p is done and old enough to be dropped.
+func (p *PendingReservation) finished() bool {
+	if p.Active() || p.State == StateAttempting {
+		return false
+	}
+	t, err := parseDate(p.Day)
+	if err != nil {
+		return true
+	}
+	return now().Sub(truncTimeToDay(t)) > keepFinished
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// window returns the target, earliest and latest acceptable tee times.
+func (p PendingReservation) window() (target, earliest, latest time.Time, err error) {
+	target, err = parseDate(p.Day)
+	if err != nil {
+		return
+	}
+	day := truncTimeToDay(target)
+	if earliest, err = timeOnDay(day, p.Earliest); err != nil {
+		return
+	}
+	if latest, err = timeOnDay(day, p.Latest); err != nil {
+		return
+	}
+	if latest.Before(earliest) {
+		err = errors.Errorf("latest time %s is before earliest %s", p.Latest, p.Earliest)
+	}
+	return
+}
+
+func timeOnDay(day time.Time, hm string) (time.Time, error) {
+	t, err := time.ParseInLocation(TimeFormat, hm, day.Location())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, day.Location()), nil
+}
+
+// rankTeeTimes returns the tee times inside the reservation's window ordered
+// by its preference.
+func rankTeeTimes(p PendingReservation, tts []golfer.TeeTime) ([]golfer.TeeTime, error) {
+	target, earliest, latest, err := p.window()
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		tt golfer.TeeTime
+		t  time.Time
+	}
+	var candidates []candidate
+	for _, tt := range tts {
+		t, err := tt.Time()
+		if err != nil {
+			return nil, err
+		}
+		if t.Before(earliest) || t.After(latest) {
+			continue
+		}
+		candidates = append(candidates, candidate{tt, t})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].t, candidates[j].t
+		switch p.Preference {
+		case PreferLatest:
+			return a.After(b)
+		case PreferEarliest:
+			return a.Before(b)
+		default:
+			da, db := absDuration(a.Sub(target)), absDuration(b.Sub(target))
+			if da != db {
+				return da < db
+			}
+			return a.Before(b)
+		}
+	})
+
+	ranked := make([]golfer.TeeTime, len(candidates))
+	for i, c := range candidates {
+		ranked[i] = c.tt
+	}
+	return ranked, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	"github.com/d4l3k/flog/golfer"
+)
+
+// quote is the price of booking a pending reservation.
+type quote struct {
+	Course  golfer.Course
+	TeeTime golfer.TeeTime
+	Holes   int
+	// Rounds is the price for each player.
+	Rounds []float64
+	Total  float64
+	// Estimated is set when the price is for a tee time on another day
+	// because the requested day can't be booked yet.
+	Estimated bool
+}
+
+// quote prices p for u with the reservation options of its best tee time. Days
+// that aren't open yet are estimated from the same time on the furthest day
+// that is.
+func (s *server) quote(u *User, p PendingReservation) (quote, error) {
+	g, err := u.golfer()
+	if err != nil {
+		return quote{}, err
+	}
+	af, err := g.Affiliation(p.ClubID)
+	if err != nil {
+		return quote{}, err
+	}
+	courses, err := s.coursesFor(g, p)
+	if err != nil {
+		return quote{}, err
+	}
+
+	bookable, err := dateIsBookable(p.Day)
+	if err != nil {
+		return quote{}, err
+	}
+	if !bookable {
+		target, err := parseDate(p.Day)
+		if err != nil {
+			return quote{}, err
+		}
+		day := now().AddDate(0, 0, daysCanBook)
+		p.Day = time.Date(day.Year(), day.Month(), day.Day(), target.Hour(), target.Minute(), 0, 0, target.Location()).Format(golfer.DateFormat)
+	}
+
+	candidates, err := s.candidates(g, af, courses, p, nil)
+	if err != nil {
+		return quote{}, err
+	}
+	for _, cand := range candidates {
+		opts, err := g.ReservationOptions(cand.course, cand.tt, golfer.AffiliationTypeIDs(af, p.Players, p.party()...), p.Holes)
+		if golfer.Classify(err) == golfer.ErrSlotTaken {
+			continue
+		}
+		if err != nil {
+			return quote{}, err
+		}
+		q := quote{
+			Course:    cand.course,
+			TeeTime:   cand.tt,
+			Holes:     p.Holes,
+			Total:     opts.Total(),
+			Estimated: !bookable,
+		}
+		if q.Holes == 0 {
+			q.Holes = cand.course.Holes
+		}
+		for _, r := range opts.Rounds {
+			q.Rounds = append(q.Rounds, r.Total())
+		}
+		return q, nil
+	}
+	return quote{}, errNoTeeTimes
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	releaseAt = flag.String("release", "00:00", "the time of day tee times are released, daysCanBook days ahead")
+)
+
+const (
+	// warmupLead is how long before a release the session is refreshed.
+	warmupLead = 2 * time.Minute
+	// pollLead is how long before a release polling starts, to allow for
+	// clock skew with the club.
+	pollLead = 2 * time.Second
+	// pollWindow is how long after a release polling continues.
+	pollWindow   = 1 * time.Minute
+	pollInterval = 250 * time.Millisecond
+	// idleRecheck is how often the sniper wakes up when nothing is pending.
+	idleRecheck = 1 * time.Hour
+)
+
+// after is used so tests can override it.
+var after = time.After
+
+var errPollDeadline = errors.New("poll deadline exceeded")
+
+// releaseTime returns the instant tee times for the given day can first be
+// booked.
+func releaseTime(day string) (time.Time, error) {
+	t, err := parseDate(day)
+	if err != nil {
+		return time.Time{}, err
+	}
+	release, err := timeOnDay(truncTimeToDay(t).AddDate(0, 0, -daysCanBook), *releaseAt)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid -release")
+	}
+	return release, nil
+}
+
+// nextRelease returns the earliest release after now and the pending
+// reservations that open at it.
+func nextRelease(pending []*PendingReservation) (time.Time, []*PendingReservation, bool) {
+	var next time.Time
+	var targets []*PendingReservation
+	for _, p := range pending {
+		if !p.Active() {
+			continue
+		}
+		release, err := releaseTime(p.Day)
+		if err != nil {
+			log.Printf("%+v", err)
+			continue
+		}
+		if !release.After(now()) {
+			continue
+		}
+		if len(targets) == 0 || release.Before(next) {
+			next = release
+			targets = nil
+		}
+		if release.Equal(next) {
+			targets = append(targets, p)
+		}
+	}
+	return next, targets, len(targets) > 0
+}
+
+// sleepUntil blocks until t, returning false if woken or stopped first.
+func sleepUntil(t time.Time, wake, stop <-chan struct{}) bool {
+	d := t.Sub(now())
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-after(d):
+		return true
+	case <-wake:
+	case <-stop:
+	}
+	return false
+}
+
+// pollUntil calls try every interval until it reports done or the deadline
+// passes. Errors from try are logged and retried.
+func pollUntil(deadline time.Time, interval time.Duration, try func() (bool, error)) error {
+	for {
+		done, err := try()
+		if err != nil {
+			log.Printf("%+v", err)
+		}
+		if done {
+			return nil
+		}
+		if !now().Add(interval).Before(deadline) {
+			return errPollDeadline
+		}
+		<-after(interval)
+	}
+}
+
+// wakeSniper makes the sniper recompute the next release, e.g. after the
+// pending reservations change.
+func (s *server) wakeSniper() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runSniper books pending reservations the instant their booking window
+// opens, instead of waiting for the next cron run.
+func (s *server) runSniper(stop <-chan struct{}) {
+	for !stopped(stop) {
+		s.mu.Lock()
+		release, targets, ok := nextRelease(s.allPending())
+		s.mu.Unlock()
+
+		if !ok {
+			sleepUntil(now().Add(idleRecheck), s.wake, stop)
+			continue
+		}
+
+		log.Printf("Next release at %s for %d reservations", release, len(targets))
+		if !sleepUntil(release.Add(-warmupLead), s.wake, stop) {
+			continue
+		}
+		s.mu.Lock()
+		s.warmUp(targets)
+		s.mu.Unlock()
+
+		if !sleepUntil(release.Add(-pollLead), nil, stop) {
+			continue
+		}
+		s.snipe(release, targets)
+	}
+}
+
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// snipe polls for tee times around release until every target has been
+// booked or the poll window ends. Targets are attempting for the duration so
+// other booking runs leave them alone.
+func (s *server) snipe(release time.Time, targets []*PendingReservation) {
+	s.mu.Lock()
+	for _, p := range targets {
+		p.setState(StateAttempting, nil)
+	}
+	if err := s.savePending(); err != nil {
+		log.Printf("%+v", err)
+	}
+	s.mu.Unlock()
+
+	remaining := targets
+	errs := map[*PendingReservation]error{}
+	err := pollUntil(release.Add(pollWindow), pollInterval, func() (bool, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var left []*PendingReservation
+		var firstErr error
+		for _, p := range remaining {
+			u := s.owner(p)
+			if u == nil {
+				continue
+			}
+			run, err := s.book(u, *p)
+			p.Attempts += len(run.Attempts)
+			// Polls before the release mostly find nothing, only keep the
+			// runs that did something.
+			if err != errNoTeeTimes || len(run.Attempts) > 0 {
+				s.record(u, *p, run, err)
+			}
+			if err != nil {
+				errs[p] = err
+				if err != errNoTeeTimes && firstErr == nil {
+					firstErr = err
+				}
+				left = append(left, p)
+				continue
+			}
+			log.Printf("Sniped %+v", p)
+			s.booked(u, p, run)
+		}
+		if len(left) != len(remaining) {
+			if err := s.savePending(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		remaining = left
+		return len(remaining) == 0, firstErr
+	})
+	if err != nil {
+		log.Printf("failed to book %d reservations released at %s: %+v", len(remaining), release, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range remaining {
+		p.setState(StateFailed, errs[p])
+		u := s.owner(p)
+		if u == nil {
+			continue
+		}
+		s.record(u, *p, Run{}, errors.Wrapf(errs[p], "gave up sniping the %s release", release.Format(TimeFormat)))
+	}
+	if err := s.savePending(); err != nil {
+		log.Printf("%+v", err)
+	}
+}
+
+// warmUp makes sure the owners of targets are logged in before a release.
+func (s *server) warmUp(targets []*PendingReservation) {
+	for _, p := range targets {
+		u := s.owner(p)
+		if u == nil {
+			continue
+		}
+		g, err := u.golfer()
+		if err == nil {
+			err = g.EnsureLoggedIn()
+		}
+		if err != nil {
+			log.Printf("failed to warm up session for %s: %+v", u.Name, err)
+		}
+	}
+}
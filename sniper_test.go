@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock replaces now and after with a clock that only advances when
+// something waits on it.
+type fakeClock struct {
+	t time.Time
+}
+
+func newFakeClock(t time.Time) *fakeClock {
+	c := &fakeClock{t: t}
+	now = func() time.Time {
+		return c.t
+	}
+	after = func(d time.Duration) <-chan time.Time {
+		c.t = c.t.Add(d)
+		ch := make(chan time.Time, 1)
+		ch <- c.t
+		return ch
+	}
+	return c
+}
+
+func TestNextRelease(t *testing.T) {
+	newFakeClock(time.Date(2018, 05, 10, 12, 0, 0, 0, time.Local))
+	pending := []*PendingReservation{
+		{Day: "2018-05-18T07:10", State: StateWaiting},
+		{Day: "2018-05-20T07:10", State: StateWaiting},
+		{Day: "2018-05-19T08:00", State: StateFailed},
+		{Day: "2018-05-19T09:00", State: StateWaiting},
+		{Day: "2018-05-19T10:00", State: StateBooked},
+	}
+	release, targets, ok := nextRelease(pending)
+	if !ok {
+		t.Fatalf("nextRelease found nothing")
+	}
+	want := time.Date(2018, 05, 11, 0, 0, 0, 0, time.Local)
+	if !release.Equal(want) {
+		t.Errorf("nextRelease = %s; not %s", release, want)
+	}
+	if len(targets) != 2 || targets[0] != pending[2] || targets[1] != pending[3] {
+		t.Errorf("nextRelease targets = %+v", targets)
+	}
+}
+
+func TestPollUntil(t *testing.T) {
+	start := time.Date(2018, 05, 11, 0, 0, 0, 0, time.Local)
+	c := newFakeClock(start)
+
+	calls := 0
+	err := pollUntil(start.Add(time.Second), 250*time.Millisecond, func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d; not 3", calls)
+	}
+	if want := start.Add(500 * time.Millisecond); !c.t.Equal(want) {
+		t.Errorf("clock = %s; not %s", c.t, want)
+	}
+
+	calls = 0
+	err = pollUntil(c.t.Add(time.Second), 250*time.Millisecond, func() (bool, error) {
+		calls++
+		return false, nil
+	})
+	if err != errPollDeadline {
+		t.Errorf("pollUntil = %v; not %v", err, errPollDeadline)
+	}
+	if calls != 4 {
+		t.Errorf("calls = %d; not 4", calls)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	storeKind    = flag.String("store", "file", "how -file is saved, file for JSON or bolt for a bbolt database. The booking history is appended to -history either way")
+	migrateStore = flag.String("migrate-store", "", "copy the data in -file to a new store of this kind at -migrate-file and exit. The booking history isn't copied, keep passing its -history path with the new store")
+	migrateFile  = flag.String("migrate-file", "", "where -migrate-store creates the new store")
+)
+
+// Data is everything flog saves.
+type Data struct {
+	DataFormatVersion int
+	// SessionKey signs session cookies and CSRF tokens.
+	SessionKey []byte
+	Users      []*User
+}
+
+// Store saves flog's data. The booking history isn't part of it, runs are
+// appended to the -history file whatever the store.
+type Store interface {
+	// Load returns the saved data upgraded to dataFormatVersion, or nil if
+	// nothing has been saved yet.
+	Load() (*Data, error)
+	Save(d *Data) error
+	Close() error
+}
+
+func openStore(kind, path string) (Store, error) {
+	switch kind {
+	case "file":
+		return fileStore{path: path}, nil
+	case "bolt":
+		return openBoltStore(path)
+	}
+	return nil, errors.Errorf("unknown store %q, must be file or bolt", kind)
+}
+
+// upgradeData migrates data read from path to the current version and
+// decodes it.
+func upgradeData(data map[string]interface{}, path string) (*Data, error) {
+	if err := migrate(data, path); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var d Data
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// fileStore saves data as a JSON file.
+type fileStore struct {
+	path string
+}
+
+func (f fileStore) Load() (*Data, error) {
+	data, err := readDataFile(f.path)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return upgradeData(data, f.path)
+}
+
+func (f fileStore) Save(d *Data) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return writeDataFile(f.path, append(b, '\n'))
+}
+
+func (fileStore) Close() error {
+	return nil
+}
+
+// copyStore is -migrate-store.
+func copyStore() error {
+	if *migrateFile == "" {
+		return errors.New("-migrate-store needs -migrate-file")
+	}
+	from, err := openStore(*storeKind, *saveFile)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := openStore(*migrateStore, *migrateFile)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	if existing, err := to.Load(); err != nil {
+		return err
+	} else if existing != nil {
+		return errors.Errorf("%s already has data", *migrateFile)
+	}
+	d, err := from.Load()
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return errors.Errorf("%s has no data to migrate", *saveFile)
+	}
+	return to.Save(d)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+func TestStores(t *testing.T) {
+	for _, kind := range []string{"file", "bolt"} {
+		t.Run(kind, func(t *testing.T) {
+			store, err := openStore(kind, filepath.Join(t.TempDir(), "flog.data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			if d, err := store.Load(); err != nil || d != nil {
+				t.Fatalf("Load of an empty store = %+v, %v", d, err)
+			}
+			want := &Data{
+				DataFormatVersion: dataFormatVersion,
+				SessionKey:        []byte("key"),
+				Users: []*User{
+					{Name: "b@example.com", Password: "b", Pending: []*PendingReservation{{ID: "p", Players: 2}}},
+					{Name: "a@example.com", Buddies: []*Buddy{{ID: "j", Player: golfer.Player{FirstName: "Jane"}}}},
+				},
+			}
+			for i := 0; i < 2; i++ {
+				if err := store.Save(want); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Load = %+v; not %+v", got, want)
+			}
+
+			want.Users = want.Users[:1]
+			if err := store.Save(want); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := store.Load(); err != nil || len(got.Users) != 1 {
+				t.Errorf("Load after removing a user = %+v, %v", got, err)
+			}
+		})
+	}
+
+	if _, err := openStore("sqlite", filepath.Join(t.TempDir(), "flog.data")); err == nil {
+		t.Errorf("opening an unknown store succeeded")
+	}
+}
+
+func TestCopyStore(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Users[0].Pending = []*PendingReservation{{ID: "p", Players: 2}}
+	s.key()
+	if err := s.savePending(); err != nil {
+		t.Fatal(err)
+	}
+
+	*migrateStore, *migrateFile = "bolt", filepath.Join(t.TempDir(), "flog.db")
+	defer func() {
+		*migrateStore, *migrateFile = "", ""
+	}()
+	if err := copyStore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyStore(); err == nil {
+		t.Errorf("copying into a store with data succeeded")
+	}
+
+	store, err := openStore("bolt", *migrateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	loaded := server{store: store}
+	if err := loaded.loadPending(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Users) != 1 || loaded.Users[0].Name != golfertest.User || len(loaded.Users[0].Pending) != 1 || !reflect.DeepEqual(loaded.SessionKey, s.SessionKey) {
+		t.Errorf("loaded = %+v", loaded.Data)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/pkg/errors"
+)
+
+// parseTeeTimesForm builds the pending reservation that browsing the tee
+// times on a day is equivalent to. It takes the same fields as the
+// reservation form except that date is a day, and the window defaults to the
+// whole day.
+func (s *server) parseTeeTimesForm(query url.Values, u *User) (*PendingReservation, error) {
+	form := url.Values{}
+	for k, v := range query {
+		form[k] = v
+	}
+	day, err := time.ParseInLocation(golfer.DayFormat, form.Get("date"), time.Local)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid date value")
+	}
+	form.Set("date", day.Format(golfer.DateFormat))
+	defaults := map[string]string{
+		"players":    "1",
+		"earliest":   "00:00",
+		"latest":     "23:59",
+		"preference": string(PreferEarliest),
+	}
+	for k, v := range defaults {
+		if form.Get(k) == "" {
+			form.Set(k, v)
+		}
+	}
+	return s.parseReserveForm(form, u)
+}
+
+// teeTimes returns the tee times in p's window, best first and full ones
+// included, and the affiliation of g's user at the club to price them with.
+// It only talks to Chronogolf, so s.mu shouldn't be held while it runs.
+func (s *server) teeTimes(g *golfer.Golfer, p PendingReservation) ([]candidate, golfer.Affiliation, error) {
+	af, err := g.Affiliation(p.ClubID)
+	if err != nil {
+		return nil, af, err
+	}
+	courses, err := s.coursesFor(g, p)
+	if err != nil {
+		return nil, af, err
+	}
+	candidates, err := s.rankedTeeTimes(g, af, courses, p)
+	return candidates, af, err
+}
+
+// bookableDays returns the days that can be booked now, formatted as
+// golfer.DayFormat.
+func bookableDays() []string {
+	var days []string
+	today := truncTimeToDay(now())
+	for i := 0; i <= daysCanBook; i++ {
+		day := today.AddDate(0, 0, i)
+		if ok, err := dateIsBookable(day.Format(golfer.DateFormat)); err != nil || !ok {
+			break
+		}
+		days = append(days, day.Format(golfer.DayFormat))
+	}
+	return days
+}
+
+// teeTimeRow is a tee time on the tee times page.
+type teeTimeRow struct {
+	Course  golfer.Course
+	TeeTime golfer.TeeTime
+	// Date is when the tee time starts formatted as golfer.DateFormat.
+	Date string
+	// Open is set if the party fits in the free slots.
+	Open bool
+	// Price is the total for the party if the tee time is open.
+	Price    float64
+	PriceErr error
+}
+
+// handleTeeTimes lists the tee times on a day for a party, so a slot can be
+// booked straight away or watched in case someone cancels.
+func (s *server) handleTeeTimes(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "must use get", 400)
+		return
+	}
+	query := r.URL.Query()
+	days := bookableDays()
+	if query.Get("date") == "" && len(days) > 0 {
+		query.Set("date", days[len(days)-1])
+	}
+
+	s.mu.Lock()
+	p, err := s.parseTeeTimesForm(query, u)
+	if err != nil {
+		s.mu.Unlock()
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	g, err := u.golfer()
+	club, _ := s.club(p.ClubID)
+	clubs := s.clubs
+	csrf := s.csrfToken(r)
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%+v", err), 500)
+		return
+	}
+
+	// Pricing takes a request per open tee time, so none of this holds s.mu.
+	candidates, af, err := s.teeTimes(g, *p)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%+v", err), 500)
+		return
+	}
+
+	var rows []teeTimeRow
+	for _, c := range candidates {
+		row := teeTimeRow{
+			Course:  c.course,
+			TeeTime: c.tt,
+			Date:    c.tt.Date + "T" + c.tt.StartTime,
+			Open:    c.fits(p.Players),
+		}
+		if row.Open {
+			opts, err := g.ReservationOptions(c.course, c.tt, golfer.AffiliationTypeIDs(af, p.Players, p.party()...), p.Holes)
+			if err != nil {
+				row.PriceErr = err
+			} else {
+				row.Price = opts.Total()
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	// The party is passed on to the reservation the actions make.
+	party := url.Values{}
+	for k, v := range query {
+		switch {
+		case k == "players", k == "holes", k == "guest_type", k == "guest", strings.HasPrefix(k, "guest_type."):
+			party[k] = v
+		}
+	}
+	renderMarkdown(w, "teetimes.md", struct {
+		CSRF     string
+		Days     []string
+		Day      string
+		Clubs    []golfer.Club
+		Club     golfer.Club
+		Pending  *PendingReservation
+		Party    url.Values
+		TeeTimes []teeTimeRow
+	}{
+		CSRF:     csrf,
+		Days:     days,
+		Day:      query.Get("date"),
+		Clubs:    clubs,
+		Club:     club,
+		Pending:  p,
+		Party:    party,
+		TeeTimes: rows,
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+var hiddenInput = regexp.MustCompile(`<input type="hidden" name="([^"]+)" value="([^"]*)">`)
+
+func TestHandleTeeTimes(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:00", 4)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:30", 1)
+	s.Users[0].Buddies = []*Buddy{{ID: "gus", Player: golfer.Player{FirstName: "Gus", LastName: "Guest"}}}
+	h := testHandler(s)
+
+	if days := bookableDays(); len(days) != 9 || days[0] != "2018-05-10" || days[8] != "2018-05-18" {
+		t.Errorf("bookableDays = %v", days)
+	}
+
+	for _, path := range []string{"/teetimes?date=tomorrow", "/teetimes?date=2018-05-17&players=5"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != 400 {
+			t.Errorf("GET %s = %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/teetimes?date=2018-05-17&players=2&guest=gus&guest_type.gus="+strconv.Itoa(golfertest.PublicAffiliationTypeID), nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	if w.Code != 200 {
+		t.Fatalf("GET /teetimes = %d: %s", w.Code, body)
+	}
+	page := string(body)
+	open := strings.Index(page, "<strong>07:00</strong>")
+	full := strings.Index(page, "<strong>07:30</strong>")
+	if open < 0 || full < open {
+		t.Fatalf("tee times missing or out of order: %s", page)
+	}
+	if price := "$" + strconv.FormatFloat(golfertest.Price+golfertest.PublicPrice, 'f', 2, 64) + " for 2 players"; !strings.Contains(page[open:full], price) || !strings.Contains(page[open:full], "Book Now") {
+		t.Errorf("open tee time isn't priced and bookable: %s", page[open:full])
+	}
+	if strings.Contains(page[full:], "$") || !strings.Contains(page[full:], "Watch This Slot") {
+		t.Errorf("full tee time isn't watchable: %s", page[full:])
+	}
+
+	// Open tee times are booked straight away, full ones are watched.
+	form := url.Values{}
+	for _, m := range hiddenInput.FindAllStringSubmatch(page[open:full], -1) {
+		form.Add(m[1], m[2])
+	}
+	if !strings.Contains(page[open:full], `action="/reserve/now"`) || !strings.Contains(page[full:], `action="/reserve"`) || !strings.Contains(page[full:], `name="watch"`) {
+		t.Errorf("wrong form actions: %s", page)
+	}
+	req := httptest.NewRequest("POST", "/reserve/now", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "Booked the 07:00 tee time on 2018-05-17") {
+		t.Fatalf("POST /reserve/now %v = %d: %s", form, w.Code, w.Body)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.Users[0].Pending) != 1 {
+		t.Fatalf("pending = %+v", s.Users[0].Pending)
+	}
+	p := s.Users[0].Pending[0]
+	if p.Day != "2018-05-17T07:00" || p.Earliest != "07:00" || p.Latest != "07:00" || p.Players != 2 || len(p.CourseIDs) != 1 || p.State != StateBooked {
+		t.Errorf("pending = %+v", p)
+	}
+	if len(p.Guests) != 1 || p.Guests[0].AffiliationTypeID != golfertest.PublicAffiliationTypeID {
+		t.Errorf("pending = %+v", p)
+	}
+}
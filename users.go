@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/pkg/errors"
+)
+
+// golferOpts are passed to every golfer.New so tests can point flog at a
+// fake Chronogolf.
+var golferOpts []golfer.Option
+
+// User is someone booking with flog. Every user books with their own
+// Chronogolf account and has their own pending reservations, buddies and
+// history.
+type User struct {
+	// Name is the user's Chronogolf email, which is also what they log into
+	// flog with.
+	Name string
+	// Password is the user's Chronogolf password if it's kept in the data
+	// file. It's empty for users whose password comes from the -pass-env,
+	// -pass-file or -secrets flags.
+	Password string `json:",omitempty"`
+	// LoginHash is the bcrypt hash of the user's flog password. Users without
+	// one need to sign up before they can log in.
+	LoginHash []byte `json:",omitempty"`
+
+	Pending []*PendingReservation
+	Buddies []*Buddy
+	// History is the last maxHistory reservations flog has booked for the
+	// user, oldest first. The history file keeps all of them.
+	History []Booking `json:",omitempty"`
+
+	g     *golfer.Golfer
+	creds golfer.Credentials
+}
+
+// credentials returns where the user's Chronogolf password comes from.
+func (u *User) credentials() golfer.Credentials {
+	switch {
+	case u.creds != nil:
+		return u.creds
+	case u.Password != "":
+		return golfer.Password(u.Password)
+	case *secretsFile != "":
+		return secretsCredentials(u.Name)
+	}
+	return nil
+}
+
+// golfer returns the user's Chronogolf session, logging in the first time
+// it's used.
+func (u *User) golfer() (*golfer.Golfer, error) {
+	if u.g != nil {
+		return u.g, nil
+	}
+	g, err := golfer.New(u.Name, u.credentials(), golferOpts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "logging in as %s", u.Name)
+	}
+	u.g = g
+	return g, nil
+}
+
+func (u *User) findPending(id string) *PendingReservation {
+	for _, p := range u.Pending {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// hasRequest returns whether u has an active pending reservation asking for
+// the same booking as pr.
+func (u *User) hasRequest(pr *PendingReservation) bool {
+	for _, p := range u.Pending {
+		if p.Active() && p.sameRequest(pr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) removePending(p *PendingReservation) {
+	for i, o := range u.Pending {
+		if o == p {
+			u.Pending = append(u.Pending[:i], u.Pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// prunePending drops reservations that finished a while ago.
+func (u *User) prunePending() {
+	var pending []*PendingReservation
+	for _, p := range u.Pending {
+		if !p.finished() {
+			pending = append(pending, p)
+		}
+	}
+	u.Pending = pending
+}
+
+func (u *User) findBuddy(id string) *Buddy {
+	for _, b := range u.Buddies {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+func (s *server) user(name string) *User {
+	for _, u := range s.Users {
+		if strings.EqualFold(u.Name, name) {
+			return u
+		}
+	}
+	return nil
+}
+
+// addUser adds a user, or updates their credentials if they already exist.
+// Passwords given directly are saved in the data file, any other
+// credentials only last until flog restarts.
+func (s *server) addUser(name string, creds golfer.Credentials) *User {
+	u := s.user(name)
+	if u == nil {
+		u = &User{Name: name}
+		s.Users = append(s.Users, u)
+	}
+	u.Password = ""
+	u.creds = creds
+	if p, ok := creds.(golfer.Password); ok {
+		u.Password = string(p)
+		u.creds = nil
+	}
+	u.g = nil
+	return u
+}
+
+// ownsLegacyHistory returns whether u gets the runs in the history file from
+// before flog had users. They were booked with the single account that
+// migrateV3 made the first user.
+func (s *server) ownsLegacyHistory(u *User) bool {
+	return len(s.Users) > 0 && s.Users[0] == u
+}
+
+// owner returns the user p belongs to or nil if it's been deleted.
+func (s *server) owner(p *PendingReservation) *User {
+	for _, u := range s.Users {
+		for _, o := range u.Pending {
+			if o == p {
+				return u
+			}
+		}
+	}
+	return nil
+}
+
+// allPending returns the pending reservations of every user.
+func (s *server) allPending() []*PendingReservation {
+	var pending []*PendingReservation
+	for _, u := range s.Users {
+		pending = append(pending, u.Pending...)
+	}
+	return pending
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/flog/golfer"
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+func TestUsers(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 4)
+	friendID := fake.AddUser("friend@example.com", "birdie")
+	h := s.routes()
+
+	signup := func(email, chronogolf, password string) *client {
+		c := &client{t: t, h: h}
+		w := c.do("POST", "/signup", url.Values{"email": {email}, "chronogolf": {chronogolf}, "password": {password}})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("POST /signup as %s = %d: %s", email, w.Code, w.Body)
+		}
+		return c
+	}
+	me := signup(golfertest.User, golfertest.Password, "my flog password")
+	friend := signup("friend@example.com", "birdie", "friend's flog password")
+	if len(s.Users) != 2 {
+		t.Fatalf("Users = %+v", s.Users)
+	}
+
+	form := url.Values{
+		"date":     {"2018-05-17T07:10"},
+		"earliest": {"07:00"},
+		"latest":   {"08:00"},
+		"players":  {"2"},
+	}
+	if w := friend.post("/reserve", form); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("POST /reserve = %d: %s", w.Code, w.Body)
+	}
+	form.Set("players", "1")
+	if w := me.post("/reserve", form); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("POST /reserve = %d: %s", w.Code, w.Body)
+	}
+
+	s.mu.Lock()
+	if len(s.Users[0].Pending) != 1 || len(s.Users[1].Pending) != 1 || s.Users[1].Pending[0].Players != 2 {
+		t.Errorf("pending = %+v, %+v", s.Users[0].Pending, s.Users[1].Pending)
+	}
+	s.mu.Unlock()
+	// Adding them started booking runs in the background.
+	s.background.Wait()
+	s.attemptBooking()
+
+	booked := map[int]int{}
+	for _, res := range fake.Reservations() {
+		booked[res.CreatedUserID] = len(res.Rounds)
+	}
+	if booked[golfertest.UserID] != 1 || booked[friendID] != 2 {
+		t.Errorf("booked rounds by user = %v", booked)
+	}
+
+	w := friend.do("GET", "/", nil)
+	body, _ := ioutil.ReadAll(w.Body)
+	if w.Code != http.StatusOK || !strings.Contains(string(body), "friend@example.com") {
+		t.Errorf("GET / as friend = %d: %s", w.Code, body)
+	}
+}
+
+func TestSecretsUsers(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddUser("friend@example.com", "birdie")
+	*secretsFile = filepath.Join(t.TempDir(), "secrets")
+	os.Setenv(*secretsPassEnv, "open sesame")
+	defer func() {
+		*secretsFile = ""
+		os.Unsetenv(*secretsPassEnv)
+	}()
+
+	*password, *passEnv = "a", "B"
+	if _, err := flagCredentials(); err == nil {
+		t.Errorf("flagCredentials with -pass and -pass-env succeeded")
+	}
+	*password, *passEnv = "", ""
+	*username = golfertest.User
+	defer func() { *username = "" }()
+	if creds, err := flagCredentials(); err != nil {
+		t.Error(err)
+	} else if _, ok := creds.(golfer.SecretsFile); !ok {
+		t.Errorf("flagCredentials with -secrets = %+v", creds)
+	}
+
+	c := &client{t: t, h: s.routes()}
+	if w := c.do("POST", "/signup", url.Values{"email": {"friend@example.com"}, "chronogolf": {"birdie"}, "password": {"long enough"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /signup = %d: %s", w.Code, w.Body)
+	}
+	data, err := ioutil.ReadFile(*saveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "birdie") {
+		t.Errorf("password saved in the data file: %s", data)
+	}
+
+	// The password comes from the secrets file after a restart.
+	var restarted server
+	if err := restarted.loadPending(); err != nil {
+		t.Fatal(err)
+	}
+	u := restarted.user("friend@example.com")
+	if u == nil || u.Password != "" {
+		t.Fatalf("friend = %+v", u)
+	}
+	if _, err := u.golfer(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"time"
+)
+
+var (
+	watchInterval = flag.Duration("watch-interval", 5*time.Minute, "how often watched reservations poll for cancellations, jittered by up to a fifth either way")
+)
+
+const (
+	// minWatchInterval rate limits -watch-interval so watching doesn't hammer
+	// Chronogolf.
+	minWatchInterval = time.Minute
+	// watchGap spaces out the polls of different reservations in a round.
+	watchGap = 2 * time.Second
+)
+
+// watchDelay returns how long to wait before the next round of polls. The
+// interval is jittered so rounds don't line up with other pollers.
+func watchDelay() time.Duration {
+	d := *watchInterval
+	if d < minWatchInterval {
+		d = minWatchInterval
+	}
+	jitter := d / 5
+	return d - jitter + time.Duration(rand.Int63n(int64(2*jitter)+1))
+}
+
+// runWatcher polls for cancellations on behalf of watched reservations until
+// stopped.
+func (s *server) runWatcher(stop <-chan struct{}) {
+	for !stopped(stop) {
+		select {
+		case <-after(watchDelay()):
+		case <-stop:
+			return
+		}
+		s.watchRound(stop)
+	}
+}
+
+// watched returns the watched reservations whose day is open for booking.
+// Before that the sniper books them at the release, and they expire once
+// their window passes.
+func (s *server) watched() []*PendingReservation {
+	var watched []*PendingReservation
+	for _, u := range s.Users {
+		for _, p := range u.Pending {
+			if !p.Watch || p.expire() || !p.Active() {
+				continue
+			}
+			if can, err := dateIsBookable(p.Day); err != nil || !can {
+				continue
+			}
+			watched = append(watched, p)
+		}
+	}
+	return watched
+}
+
+// watchRound polls each watched reservation once.
+func (s *server) watchRound(stop <-chan struct{}) {
+	s.mu.Lock()
+	watched := s.watched()
+	s.mu.Unlock()
+
+	for i, p := range watched {
+		if i > 0 {
+			select {
+			case <-after(watchGap):
+			case <-stop:
+				return
+			}
+		}
+		s.mu.Lock()
+		s.watch(p)
+		s.mu.Unlock()
+	}
+}
+
+// watch checks whether a tee time that fits p has opened up, e.g. because
+// someone cancelled, and books it. Only the tee time that was seen open is
+// tried. s.mu must be held, it's released while polling.
+func (s *server) watch(p *PendingReservation) {
+	u := s.owner(p)
+	if u == nil || !p.Watch || !p.Active() {
+		return
+	}
+	g, err := u.golfer()
+	if err != nil {
+		log.Printf("watching %s for %s: %+v", p.Day, u.Name, err)
+		return
+	}
+	q := *p
+	s.mu.Unlock()
+	candidates, _, err := s.teeTimes(g, q)
+	s.mu.Lock()
+	if err != nil {
+		log.Printf("watching %s for %s: %+v", p.Day, u.Name, err)
+		return
+	}
+	// p may have been paused, changed or booked while polling.
+	if s.owner(p) != u || !p.Watch || !p.Active() || !p.sameRequest(&q) {
+		return
+	}
+	for _, c := range candidates {
+		if !c.fits(p.Players) {
+			continue
+		}
+		log.Printf("%s %s on %s opened up for %s", c.tt.Date, c.tt.StartTime, c.course.Name, u.Name)
+		slot := *p
+		slot.CourseIDs = []int{c.course.ID}
+		slot.Earliest = c.tt.StartTime
+		slot.Latest = c.tt.StartTime
+		if _, err := s.attemptAs(u, p, slot); err != nil {
+			log.Printf("%s: %+v", u.Name, err)
+		}
+		if err := s.savePending(); err != nil {
+			log.Printf("%+v", err)
+		}
+		return
+	}
+}
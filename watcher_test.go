@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/d4l3k/flog/golfer/golfertest"
+)
+
+func TestWatchDelay(t *testing.T) {
+	defer func(d time.Duration) { *watchInterval = d }(*watchInterval)
+
+	for _, tc := range []struct {
+		interval, base time.Duration
+	}{
+		{10 * time.Minute, 10 * time.Minute},
+		{time.Second, minWatchInterval},
+	} {
+		*watchInterval = tc.interval
+		for i := 0; i < 100; i++ {
+			if d := watchDelay(); d < tc.base*4/5 || d > tc.base*6/5 {
+				t.Fatalf("watchDelay() with -watch-interval=%s = %s", tc.interval, d)
+			}
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s, fake := newTestServer(t)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:10", 0)
+	later := fake.AddTeeTime(golfertest.CourseID, "2018-05-17", "07:40", 1)
+	fake.AddTeeTime(golfertest.CourseID, "2018-05-25", "07:10", 4)
+
+	watched := &PendingReservation{ID: "watched", ClubID: golfertest.ClubID, Day: "2018-05-17T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateFailed, Watch: true}
+	unwatched := &PendingReservation{ID: "unwatched", ClubID: golfertest.ClubID, Day: "2018-05-17T07:10", Players: 1, Earliest: "07:00", Latest: "08:00", State: StateFailed}
+	closed := &PendingReservation{ID: "closed", ClubID: golfertest.ClubID, Day: "2018-05-25T07:10", Players: 2, Earliest: "07:00", Latest: "08:00", State: StateWaiting, Watch: true}
+	s.Users[0].Pending = []*PendingReservation{watched, unwatched, closed}
+
+	// Nothing has room for the party yet.
+	s.watchRound(nil)
+	if watched.State != StateFailed || watched.Attempts != 0 {
+		t.Errorf("watched = %+v", watched)
+	}
+	if reservations := fake.Reservations(); len(reservations) != 0 {
+		t.Fatalf("reservations = %+v", reservations)
+	}
+
+	// Someone cancels. Only the tee time with room is tried even though the
+	// full one is closer to the target.
+	fake.ReleaseSlots(later.ID, 1)
+	s.watchRound(nil)
+	if watched.State != StateBooked || watched.Attempts != 1 {
+		t.Errorf("watched = %+v", watched)
+	}
+	reservations := fake.Reservations()
+	if len(reservations) != 1 || reservations[0].TeetimeID != later.ID {
+		t.Errorf("reservations = %+v", reservations)
+	}
+	if unwatched.State != StateFailed || unwatched.Attempts != 0 {
+		t.Errorf("unwatched = %+v", unwatched)
+	}
+	if closed.State != StateWaiting || closed.Attempts != 0 {
+		t.Errorf("closed = %+v", closed)
+	}
+	if len(s.Users[0].History) != 1 {
+		t.Errorf("History = %+v", s.Users[0].History)
+	}
+}